@@ -17,6 +17,7 @@
 package backend
 
 import (
+	"context"
 	pb "github.com/chenquan/hit/internal/remotecache"
 )
 
@@ -31,18 +32,60 @@ type Discovery interface {
 	// 获取节点数据
 	GetNodes() map[string]string
 }
+
+// Invalidator 在客户端实例之间广播本地(一级)缓存失效事件
+type Invalidator interface {
+	// 发布分组中keys失效,发布者自身不会收到该事件.发布遵循ctx的截止时间与取消
+	Publish(ctx context.Context, group string, keys []string) error
+	// 订阅其他实例发布的失效事件,group与keys均为空时表示可能丢失了事件,应删除所有本地缓存
+	Subscribe(handler func(group string, keys []string))
+}
+
+// Client 客户端使用的节点发现实现,例如etcd.Client与static.Client
+type Client interface {
+	Discovery
+	NodePicker
+	Invalidator
+}
+
 type NodePicker interface {
 	PickNode(key string) (node Nodor, ok bool)
+	// 沿哈希环获取key的至多n个不同节点,第一个即为PickNode的结果
+	PickNodes(key string, n int) []Nodor
+}
+
+// ResponseError 节点正常响应但操作失败,例如key不存在.此时不会重试其他节点
+type ResponseError struct {
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return "message: " + e.Message
 }
 
 type NodeGetter interface {
-	Get(in *pb.GetRequest, out *pb.GetResponse) error
+	Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error
 }
 type NodeSetter interface {
-	Set(in *pb.SetRequest, out *pb.SetResponse) error
+	Set(ctx context.Context, in *pb.SetRequest, out *pb.SetResponse) error
 }
 type NodeDeler interface {
-	Del(in *pb.DelRequest, out *pb.DelResponse) error
+	Del(ctx context.Context, in *pb.DelRequest, out *pb.DelResponse) error
+}
+
+// NodeMultiGetter 批量获取
+type NodeMultiGetter interface {
+	MultiGet(ctx context.Context, in *pb.MultiGetRequest, out *pb.MultiGetResponse) error
+}
+
+// NodeMultiSetter 批量新增
+type NodeMultiSetter interface {
+	MultiSet(ctx context.Context, in *pb.MultiSetRequest, out *pb.MultiSetResponse) error
+}
+
+// NodeMultiDeler 批量删除
+type NodeMultiDeler interface {
+	MultiDel(ctx context.Context, in *pb.MultiDelRequest, out *pb.MultiDelResponse) error
 }
 
 // 节点
@@ -50,5 +93,8 @@ type Nodor interface {
 	NodeGetter
 	NodeSetter
 	NodeDeler
+	NodeMultiGetter
+	NodeMultiSetter
+	NodeMultiDeler
 	Url() string
 }
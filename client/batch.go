@@ -0,0 +1,446 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/chenquan/hit/client/backend"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 批量操作: 按节点对key分组,每个节点只发送一次请求,各节点的请求并发执行.
+// 单个key的失败记录在返回的错误集合中,不影响其他key.
+// 开启副本时,批量写入与删除发送到key的所有副本节点,批量获取在主节点失败时依次读取副本.
+
+// nodeKeys 属于同一节点的key
+type nodeKeys struct {
+	node backend.Nodor
+	keys []string
+}
+
+// batchResult 并发写入的批量结果
+type batchResult struct {
+	mu      sync.Mutex
+	values  map[string]cachebackend.Valuer
+	errs    map[string]error
+	reached map[string]struct{} // 至少一个副本删除成功的key
+}
+
+func newBatchResult() *batchResult {
+	return &batchResult{
+		values:  make(map[string]cachebackend.Valuer),
+		errs:    make(map[string]error),
+		reached: make(map[string]struct{}),
+	}
+}
+
+// setReached 记录key在一个副本上删除成功,该key其他副本的错误仍然保留
+func (r *batchResult) setReached(key string) {
+	r.mu.Lock()
+	r.reached[key] = struct{}{}
+	r.mu.Unlock()
+}
+
+// setValue 记录key的值,覆盖该key此前的错误(任一副本成功即视为成功)
+func (r *batchResult) setValue(key string, value cachebackend.Valuer) {
+	r.mu.Lock()
+	r.values[key] = value
+	delete(r.errs, key)
+	r.mu.Unlock()
+}
+
+// setErr 记录key的错误,key已有值时忽略
+func (r *batchResult) setErr(key string, err error) {
+	r.mu.Lock()
+	if _, ok := r.values[key]; !ok {
+		r.errs[key] = err
+	}
+	r.mu.Unlock()
+}
+
+// groupByNode 按key的第round个候选节点(0为主节点)对key分组,
+// 候选节点不足n个时使用实际个数,没有第round个候选节点的key放入local
+func (g *Group) groupByNode(keys []string, round, n int) (nodes map[string]*nodeKeys, local []string) {
+	nodes = make(map[string]*nodeKeys)
+	for _, key := range keys {
+		if g.nodes == nil {
+			local = append(local, key)
+			continue
+		}
+		candidates := g.nodes.PickNodes(key, n)
+		if round >= len(candidates) {
+			local = append(local, key)
+			continue
+		}
+		addNodeKey(nodes, candidates[round], key)
+	}
+	return
+}
+
+// groupByReplicas 将key分配到其所有副本节点
+func (g *Group) groupByReplicas(keys []string) (nodes map[string]*nodeKeys, local []string) {
+	nodes = make(map[string]*nodeKeys)
+	for _, key := range keys {
+		var replicas []backend.Nodor
+		if g.nodes != nil {
+			replicas = g.nodes.PickNodes(key, g.replicationFactor())
+		}
+		if len(replicas) == 0 {
+			local = append(local, key)
+			continue
+		}
+		for _, node := range replicas {
+			addNodeKey(nodes, node, key)
+		}
+	}
+	return
+}
+
+func addNodeKey(nodes map[string]*nodeKeys, node backend.Nodor, key string) {
+	nk, ok := nodes[node.Url()]
+	if !ok {
+		nk = &nodeKeys{node: node}
+		nodes[node.Url()] = nk
+	}
+	nk.keys = append(nk.keys, key)
+}
+
+// uniqueKeys 去除重复key.空key记录一条错误,错误中包含空key的个数
+func uniqueKeys(keys []string, result *batchResult) []string {
+	seen := make(map[string]struct{}, len(keys))
+	unique := make([]string, 0, len(keys))
+	empty := 0
+	for _, key := range keys {
+		if key == "" {
+			empty++
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, key)
+	}
+	if empty > 0 {
+		result.errs[""] = fmt.Errorf("key is required: %d empty keys", empty)
+	}
+	return unique
+}
+
+// GetMulti 批量获取,优先读取本地(一级)缓存,其余key按节点分组并发获取,
+// 节点获取失败的key通过Getter加载.返回成功获取的值以及失败key对应的错误
+func (g *Group) GetMulti(keys []string) (map[string]cachebackend.Valuer, map[string]error) {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext 同GetMulti,访问节点及调用Getter时遵循ctx的截止时间与取消
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) (map[string]cachebackend.Valuer, map[string]error) {
+	result := newBatchResult()
+	var missing []string
+	now := time.Now().Unix()
+	for _, key := range uniqueKeys(keys, result) {
+		if v, ok := g.mainCache.Get(key); ok {
+			if expire := v.Expire(); expire <= 0 || expire > now {
+				result.values[key] = v
+				continue
+			}
+			g.expire(key)
+		}
+		missing = append(missing, key)
+	}
+
+	// 与withFailover一致:第round轮从key的第round个候选节点获取,节点不可用的key进入下一轮,
+	// 节点上不存在的key仅在副本之间进入下一轮,候选节点用尽后通过Getter加载
+	attempts := g.retry.attempts
+	if replication := g.replicationFactor(); attempts < replication {
+		attempts = replication
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := g.retry.backoff
+	var local []string
+	for round := 0; len(missing) > 0; round++ {
+		nodes, exhausted := g.groupByNode(missing, round, attempts)
+		local = append(local, exhausted...)
+		var (
+			wg          sync.WaitGroup
+			mu          sync.Mutex
+			notFound    []string
+			unavailable []string
+		)
+		for _, nk := range nodes {
+			wg.Add(1)
+			go func(nk *nodeKeys) {
+				defer wg.Done()
+				absent, failed := g.multiGetFromNode(ctx, nk, result)
+				mu.Lock()
+				notFound = append(notFound, absent...)
+				unavailable = append(unavailable, failed...)
+				mu.Unlock()
+			}(nk)
+		}
+		wg.Wait()
+		if round < g.replicationFactor()-1 {
+			missing = append(unavailable, notFound...)
+		} else {
+			missing = unavailable
+			local = append(local, notFound...)
+		}
+		// 仅在节点不可用后等待
+		if len(unavailable) > 0 {
+			if sleep(ctx, backoff) != nil {
+				local = append(local, missing...)
+				break
+			}
+			backoff *= 2
+		}
+	}
+	g.getLocallyMulti(ctx, local, result)
+	return result.values, result.errs
+}
+
+// multiGetFromNode 在访问节点超时时间内从节点批量获取数据,
+// 返回节点上不存在的key以及节点不可用时获取失败的key
+func (g *Group) multiGetFromNode(ctx context.Context, nk *nodeKeys, result *batchResult) (notFound, failed []string) {
+	atomic.AddInt64(&g.counters.remoteLoads, 1)
+	in := &pb.MultiGetRequest{Group: g.name, Keys: nk.keys}
+	out := &pb.MultiGetResponse{}
+	nodeCtx, cancel := g.nodeContext(ctx)
+	defer cancel()
+	if err := nk.node.MultiGet(nodeCtx, in, out); err != nil {
+		atomic.AddInt64(&g.counters.peerErrors, 1)
+		log.Println("[Hit] Failed to get from peer", err)
+		return nil, nk.keys
+	}
+
+	found := make(map[string]struct{}, len(out.Results))
+	for _, r := range out.Results {
+		if !r.Success || r.Data == nil {
+			continue
+		}
+		found[r.Key] = struct{}{}
+		value := lru.NewValue(r.Data.Value, r.Data.Expire, r.Data.Group)
+		g.populateCache(r.Key, lru.NewValue(value.Bytes(), localExpire(value.Expire()), value.GroupName()))
+		result.setValue(r.Key, value)
+	}
+	for _, key := range nk.keys {
+		if _, ok := found[key]; !ok {
+			notFound = append(notFound, key)
+		}
+	}
+	return notFound, nil
+}
+
+// getLocallyMulti 通过Getter并发加载数据,同一key的并发加载与Get共享一次加载
+func (g *Group) getLocallyMulti(ctx context.Context, keys []string, result *batchResult) {
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if err := ctx.Err(); err != nil {
+				result.setErr(key, err)
+				return
+			}
+			do, err := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+				ctx, cancel := detachContext(ctx)
+				defer cancel()
+				atomic.AddInt64(&g.counters.localLoads, 1)
+				return g.getLocally(ctx, key)
+			})
+			if err != nil {
+				result.setErr(key, err)
+				return
+			}
+			result.setValue(key, do.(cachebackend.Valuer))
+		}(key)
+	}
+	wg.Wait()
+}
+
+// SetMulti 批量新增,按节点分组并发写入.isLocalCache为true时同时写入本地(一级)缓存.
+// 返回节点写入后的值以及失败key对应的错误
+func (g *Group) SetMulti(values map[string]cachebackend.Valuer, isLocalCache bool) (map[string]cachebackend.Valuer, map[string]error) {
+	return g.SetMultiContext(context.Background(), values, isLocalCache)
+}
+
+// SetMultiContext 同SetMulti,写入节点时遵循ctx的截止时间与取消
+func (g *Group) SetMultiContext(ctx context.Context, values map[string]cachebackend.Valuer, isLocalCache bool) (map[string]cachebackend.Valuer, map[string]error) {
+	result := newBatchResult()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	keys = uniqueKeys(keys, result)
+	// 写入本地缓存,不写入本地缓存时删除本地缓存中的旧值
+	localValues := make(map[string]cachebackend.Valuer)
+	if isLocalCache {
+		for _, key := range keys {
+			value := values[key]
+			localValues[key] = lru.NewValue(value.Bytes(), localExpire(value.Expire()), value.GroupName())
+			g.populateCache(key, localValues[key])
+		}
+	} else {
+		g.invalidateLocal(keys)
+	}
+
+	// 与Set一致,没有可用节点的key只写入本地缓存,视为成功
+	nodes, local := g.groupByReplicas(keys)
+	localOnly := make(map[string]struct{}, len(local))
+	for _, key := range local {
+		localOnly[key] = struct{}{}
+		if value, ok := localValues[key]; ok {
+			result.values[key] = value
+		}
+	}
+	var wg sync.WaitGroup
+	for _, nk := range nodes {
+		wg.Add(1)
+		go func(nk *nodeKeys) {
+			defer wg.Done()
+			g.multiSetFromNode(ctx, nk, values, result)
+		}(nk)
+	}
+	wg.Wait()
+
+	// 至少一个副本写入成功的key广播失效事件
+	written := make([]string, 0, len(result.values))
+	for key := range result.values {
+		if _, ok := localOnly[key]; ok {
+			continue
+		}
+		written = append(written, key)
+	}
+	g.publish(ctx, written...)
+	return result.values, result.errs
+}
+
+// multiSetFromNode 向节点批量写入数据
+func (g *Group) multiSetFromNode(ctx context.Context, nk *nodeKeys, values map[string]cachebackend.Valuer, result *batchResult) {
+	in := &pb.MultiSetRequest{Group: g.name}
+	now := time.Now().Unix()
+	for _, key := range nk.keys {
+		value := values[key]
+		ttl, ok := requestTTL(value.Expire(), now)
+		if !ok {
+			result.setErr(key, fmt.Errorf("value of key %s has expired", key))
+			continue
+		}
+		in.Items = append(in.Items, &pb.SetItem{Key: key, Value: value.Bytes(), Ttl: ttl})
+	}
+	if len(in.Items) == 0 {
+		return
+	}
+
+	out := &pb.MultiSetResponse{}
+	if err := nk.node.MultiSet(ctx, in, out); err != nil {
+		atomic.AddInt64(&g.counters.peerErrors, 1)
+		log.Println("[Hit] Failed to set to peer", err)
+		for _, item := range in.Items {
+			result.setErr(item.Key, err)
+		}
+		return
+	}
+	g.collectSetResults(in.Items, out.Results, result)
+}
+
+// collectSetResults 记录节点返回的每个key的结果,节点未返回的key视为失败
+func (g *Group) collectSetResults(items []*pb.SetItem, results []*pb.Result, result *batchResult) {
+	returned := make(map[string]*pb.Result, len(results))
+	for _, r := range results {
+		returned[r.Key] = r
+	}
+	for _, item := range items {
+		r, ok := returned[item.Key]
+		switch {
+		case !ok:
+			result.setErr(item.Key, fmt.Errorf("no result for key %s", item.Key))
+		case !r.Success || r.Data == nil:
+			result.setErr(item.Key, fmt.Errorf("message: %s", r.Message))
+		default:
+			result.setValue(item.Key, lru.NewValue(r.Data.Value, r.Data.Expire, r.Data.Group))
+		}
+	}
+}
+
+// DelMulti 批量删除,同时删除本地(一级)缓存.返回失败key对应的错误
+func (g *Group) DelMulti(keys []string) map[string]error {
+	return g.DelMultiContext(context.Background(), keys)
+}
+
+// DelMultiContext 同DelMulti,访问节点时遵循ctx的截止时间与取消
+func (g *Group) DelMultiContext(ctx context.Context, keys []string) map[string]error {
+	result := newBatchResult()
+	keys = uniqueKeys(keys, result)
+	g.invalidateLocal(keys)
+
+	nodes, _ := g.groupByReplicas(keys)
+	var wg sync.WaitGroup
+	for _, nk := range nodes {
+		wg.Add(1)
+		go func(nk *nodeKeys) {
+			defer wg.Done()
+			in := &pb.MultiDelRequest{Group: g.name, Keys: nk.keys}
+			out := &pb.MultiDelResponse{}
+			if err := nk.node.MultiDel(ctx, in, out); err != nil {
+				atomic.AddInt64(&g.counters.peerErrors, 1)
+				log.Println("[Hit] Failed to delete from peer", err)
+				for _, key := range nk.keys {
+					result.setErr(key, err)
+				}
+				return
+			}
+			g.collectDelResults(nk.keys, out.Results, result)
+		}(nk)
+	}
+	wg.Wait()
+
+	// 至少一个副本删除成功的key广播失效事件
+	deleted := make([]string, 0, len(result.reached))
+	for key := range result.reached {
+		deleted = append(deleted, key)
+	}
+	g.publish(ctx, deleted...)
+	return result.errs
+}
+
+// collectDelResults 记录节点返回的每个key的删除结果,节点未返回的key视为失败
+func (g *Group) collectDelResults(keys []string, results []*pb.Result, result *batchResult) {
+	returned := make(map[string]*pb.Result, len(results))
+	for _, r := range results {
+		returned[r.Key] = r
+	}
+	for _, key := range keys {
+		r, ok := returned[key]
+		switch {
+		case !ok:
+			result.setErr(key, fmt.Errorf("no result for key %s", key))
+		case !r.Success:
+			result.setErr(key, fmt.Errorf("message: %s", r.Message))
+		default:
+			result.setReached(key)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"errors"
+	"github.com/chenquan/hit/client/backend"
+	"github.com/chenquan/hit/client/dial"
+	"github.com/chenquan/hit/internal/cache"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consistenthash"
+	"github.com/chenquan/hit/internal/consts"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/chenquan/hit/internal/server"
+	"github.com/chenquan/hit/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// picker 基于一致性哈希的测试节点选择器
+type picker struct {
+	peers *consistenthash.Map
+	nodes map[string]backend.Nodor
+}
+
+func newPicker(urls ...string) *picker {
+	p := &picker{peers: consistenthash.New(3, nil), nodes: make(map[string]backend.Nodor)}
+	p.peers.Add(urls...)
+	for _, u := range urls {
+		p.nodes[u] = dial.NewNode(u)
+	}
+	return p
+}
+
+func (p *picker) PickNode(key string) (backend.Nodor, bool) {
+	node, ok := p.nodes[p.peers.Get(key)]
+	return node, ok
+}
+
+func (p *picker) PickNodes(key string, n int) []backend.Nodor {
+	var nodes []backend.Nodor
+	for _, name := range p.peers.GetN(key, n) {
+		nodes = append(nodes, p.nodes[name])
+	}
+	return nodes
+}
+
+// startNodes 启动n个进程内节点,返回节点地址以及各节点收到的请求数
+func startNodes(t *testing.T, n int) ([]string, []*int64) {
+	var urls []string
+	var counts []*int64
+	for i := 0; i < n; i++ {
+		count := new(int64)
+		pool := server.NewHTTPPool()
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(count, 1)
+			pool.ServeHTTP(w, r)
+		}))
+		t.Cleanup(s.Close)
+		urls = append(urls, s.URL+consts.DefaultBasePath)
+		counts = append(counts, count)
+	}
+	return urls, counts
+}
+
+func newBatchGroup(name string, nodes backend.NodePicker, getter GetterFunc) *Group {
+	return &Group{
+		name:      name,
+		getter:    getter,
+		mainCache: cache.NewSyncCacheDefault(0),
+		nodes:     nodes,
+		loader:    &utils.Loader{},
+	}
+}
+
+func TestBatch(t *testing.T) {
+	server.NewGroupDefault("batch", 0)
+	urls, counts := startNodes(t, 2)
+	g := newBatchGroup("batch", newPicker(urls...), func(key string) ([]byte, error) {
+		if key == "absent" {
+			return nil, errors.New("absent")
+		}
+		return []byte("db-" + key), nil
+	})
+
+	values := make(map[string]cachebackend.Valuer)
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		values[key] = lru.NewValue([]byte("v"+strconv.Itoa(i)), time.Now().Add(time.Minute).Unix(), "batch")
+	}
+	stored, errs := g.SetMulti(values, false)
+	if len(errs) != 0 || len(stored) != len(values) {
+		t.Fatalf("SetMulti: stored=%d errs=%v", len(stored), errs)
+	}
+	// 每个节点只收到一次请求
+	for i, count := range counts {
+		if n := atomic.LoadInt64(count); n != 1 {
+			t.Fatalf("node %d received %d requests", i, n)
+		}
+	}
+
+	got, errs := g.GetMulti(append(keys, "absent", "fresh"))
+	if len(got) != len(keys)+1 {
+		t.Fatalf("GetMulti returned %d values", len(got))
+	}
+	for key, value := range values {
+		if string(got[key].Bytes()) != string(value.Bytes()) {
+			t.Errorf("key %s: expected %s but got %s", key, value.Bytes(), got[key].Bytes())
+		}
+	}
+	// 节点未命中的key通过Getter加载,加载失败的key返回错误
+	if string(got["fresh"].Bytes()) != "db-fresh" {
+		t.Errorf("expected fresh to be loaded locally")
+	}
+	if _, ok := errs["absent"]; !ok || len(errs) != 1 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	if errs := g.DelMulti(keys[:10]); len(errs) != 0 {
+		t.Fatalf("DelMulti: %v", errs)
+	}
+	for _, key := range keys[:10] {
+		if _, ok := g.mainCache.Get(key); ok {
+			t.Errorf("key %s still in local cache", key)
+		}
+		if _, err := server.GetGroup("batch").Get(key); err == nil {
+			t.Errorf("key %s still on node", key)
+		}
+	}
+}
+
+func TestBatchNodeDown(t *testing.T) {
+	urls, _ := startNodes(t, 1)
+	g := newBatchGroup("batch-down", newPicker(urls[0]+"/unreachable:0"), func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	})
+	got, errs := g.GetMulti([]string{"a", "b"})
+	if len(errs) != 0 || string(got["a"].Bytes()) != "db-a" {
+		t.Fatalf("expected fallback to Getter, got %v %v", got, errs)
+	}
+	_, errs = g.SetMulti(map[string]cachebackend.Valuer{"a": lru.NewValue([]byte("a"), 0, "batch-down")}, false)
+	if errs["a"] == nil {
+		t.Fatalf("expected error for failed node")
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	server.NewGroupDefault("set-ttl", 0, server.WithTTL(time.Hour, 0))
+	urls, _ := startNodes(t, 1)
+	g := newBatchGroup("set-ttl", newPicker(urls...), func(key string) ([]byte, error) {
+		return nil, errors.New("absent")
+	})
+
+	now := time.Now()
+	testCases := []struct {
+		key    string
+		expire int64
+		expect int64 // 节点上的到期时间,0表示永不过期
+	}{
+		{"default", 0, now.Add(time.Hour).Unix()},
+		{"forever", -1, 0},
+		{"minute", now.Add(time.Minute).Unix(), now.Add(time.Minute).Unix()},
+	}
+	for _, testCase := range testCases {
+		value := lru.NewValue([]byte("v"), testCase.expire, "set-ttl")
+		if _, err := g.Set(testCase.key, value, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, errs := g.SetMulti(map[string]cachebackend.Valuer{testCase.key + "-multi": value}, false); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+		for _, key := range []string{testCase.key, testCase.key + "-multi"} {
+			v, err := server.GetGroup("set-ttl").Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := v.Expire() - testCase.expect; diff < -1 || diff > 1 {
+				t.Errorf("key %s: expected expire %d but got %d", key, testCase.expect, v.Expire())
+			}
+		}
+	}
+}
+
+func TestBatchResults(t *testing.T) {
+	g := newBatchGroup("batch-results", nil, nil)
+	result := newBatchResult()
+	keys := uniqueKeys([]string{"a", "", "b", "a", ""}, result)
+	if len(keys) != 2 || result.errs[""] == nil || !strings.Contains(result.errs[""].Error(), "2 empty keys") {
+		t.Fatalf("unexpected keys %v errs %v", keys, result.errs)
+	}
+
+	// 节点未返回的key视为删除失败
+	g.collectDelResults(keys, []*pb.Result{{Key: "a", Success: true}}, result)
+	if _, ok := result.errs["a"]; ok {
+		t.Errorf("key a should be deleted")
+	}
+	if result.errs["b"] == nil {
+		t.Errorf("expected error for missing result of key b")
+	}
+
+	// 与Set一致,没有节点时只写入本地缓存
+	values, errs := g.SetMulti(map[string]cachebackend.Valuer{"c": lru.NewValue([]byte("v"), 0, "batch-results")}, true)
+	if len(errs) != 0 || values["c"] == nil {
+		t.Fatalf("expected local write to succeed: %v %v", values, errs)
+	}
+	if _, ok := g.mainCache.Get("c"); !ok {
+		t.Fatalf("expected key c in local cache")
+	}
+}
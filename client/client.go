@@ -17,11 +17,13 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/chenquan/hit/client/backend"
 	"github.com/chenquan/hit/client/etcd"
 	"github.com/chenquan/hit/client/hit"
+	"github.com/chenquan/hit/client/static"
 	"github.com/chenquan/hit/internal/cache"
 	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
 	"github.com/chenquan/hit/internal/cache/lru"
@@ -29,6 +31,7 @@ import (
 	pb "github.com/chenquan/hit/internal/remotecache"
 	"github.com/chenquan/hit/internal/utils"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"log"
@@ -36,16 +39,45 @@ import (
 )
 
 type Hit struct {
-	client *etcd.Client
-	groups map[string]*Group
-	rwLock sync.RWMutex
+	client      backend.Client
+	groups      map[string]*Group
+	rwLock      sync.RWMutex
+	retry       retry         // 访问节点失败时的故障转移策略
+	replication int           // 副本数
+	timeout     time.Duration // 访问单个节点的超时时间
 }
 
+// NewHit 配置了Nodes或NodesFile时使用静态节点列表或节点列表文件发现节点,否则使用etcd
 func NewHit(config *hit.Config) *Hit {
-	return &Hit{
-		client: etcd.NewClient(config),
-		groups: make(map[string]*Group),
+	var client backend.Client
+	var err error
+	switch {
+	case len(config.Nodes) > 0:
+		client, err = static.NewClient(config)
+	case config.NodesFile != "":
+		client, err = static.NewFileClient(config)
+	default:
+		client = etcd.NewClient(config)
 	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(0)
+	}
+	return NewHitWithClient(config, client)
+}
+
+// NewHitWithClient 使用指定的服务发现实现
+func NewHitWithClient(config *hit.Config, client backend.Client) *Hit {
+	h := &Hit{
+		client:      client,
+		groups:      make(map[string]*Group),
+		retry:       retry{attempts: config.NodeAttempts(), backoff: config.NodeBackoff()},
+		replication: config.ReplicationFactor(),
+		timeout:     config.NodeTimeout(),
+	}
+	// 订阅其他实例发布的本地缓存失效事件
+	h.client.Subscribe(h.invalidate)
+	return h
 }
 func NewHitFromPath(path string) *Hit {
 
@@ -62,20 +94,36 @@ func NewHitFromPath(path string) *Hit {
 
 // NewGroup create a new instance of Loader
 func (h *Hit) NewGroupDefault(name, nodeName string, cacheBytes int64, getter Getter) *Group {
-	group := h.NewGroup(name, nodeName, cache.NewSyncCacheDefault(cacheBytes), getter)
+	mainCache := cache.NewSyncCacheDefault(cacheBytes)
+	mainCache.StartJanitor(consts.DefaultJanitorInterval)
+	group := h.NewGroup(name, nodeName, mainCache, getter)
 	return group
 }
-func (h *Hit) NewGroup(name, nodeName string, mainCache *cache.SyncCache, getter Getter) *Group {
+
+// NewGroupSharded 创建使用分片缓存的分组,shards为分片数
+func (h *Hit) NewGroupSharded(name, nodeName string, cacheBytes int64, shards int, getter Getter) *Group {
+	mainCache := cache.NewShardedSyncCacheDefault(cacheBytes, shards)
+	mainCache.StartJanitor(consts.DefaultJanitorInterval)
+	return h.NewGroup(name, nodeName, mainCache, getter)
+}
+
+// NewGroup mainCache必须是并发安全的,例如cache.SyncCache或cache.ShardedSyncCache
+func (h *Hit) NewGroup(name, nodeName string, mainCache cachebackend.Cache, getter Getter) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	h.rwLock.Lock()
 	defer h.rwLock.Unlock()
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: mainCache,
-		loader:    &utils.Loader{},
+		name:        name,
+		getter:      getter,
+		mainCache:   mainCache,
+		loader:      &utils.Loader{},
+		retry:       h.retry,
+		replication: h.replication,
+		timeout:     h.timeout,
+		// 广播本地缓存失效事件
+		invalidator: h.client,
 	}
 	nodes, err := h.client.PullNodes(nodeName)
 	if err != nil {
@@ -95,12 +143,33 @@ func (h *Hit) GetGroup(name string) *Group {
 	return g
 }
 
+// invalidate 删除其他实例修改过的key在本地(一级)缓存中的数据,group为空时删除所有分组的本地缓存
+func (h *Hit) invalidate(group string, keys []string) {
+	if group == "" {
+		h.rwLock.RLock()
+		defer h.rwLock.RUnlock()
+		for _, g := range h.groups {
+			g.mainCache.Clear()
+		}
+		return
+	}
+	if g := h.GetGroup(group); g != nil {
+		g.invalidateLocal(keys)
+	}
+}
+
 type Group struct {
-	name      string
-	getter    Getter
-	mainCache *cache.SyncCache
-	nodes     backend.NodePicker
-	loader    *utils.Loader
+	counters    counters
+	name        string
+	getter      Getter
+	mainCache   cachebackend.Cache
+	nodes       backend.NodePicker
+	loader      *utils.Loader
+	retry       retry         // 访问节点失败时的故障转移策略
+	replication int           // 副本数,key存储在哈希环上的前replication个不同节点
+	timeout     time.Duration // 访问单个节点的超时时间,见withFailover
+
+	invalidator backend.Invalidator // 广播本地缓存失效事件,为nil时不广播
 }
 
 // GetterFunc 通过函数实现Getter
@@ -116,6 +185,25 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// GetterContext 加载key的数据,加载过程应随ctx取消或超时而终止.
+// 传入分组的Getter同时实现GetterContext时,优先调用GetContext
+type GetterContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterContextFunc 通过函数实现GetterContext,同时实现了Getter
+type GetterContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+// GetContext implements GetterContext interface function
+func (f GetterContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get implements Getter interface function
+func (f GetterContextFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
 func (g *Group) registerPeers(nodes backend.NodePicker) {
 	if g.nodes != nil {
 		panic("RegisterPeerPicker called more than once")
@@ -125,15 +213,23 @@ func (g *Group) registerPeers(nodes backend.NodePicker) {
 
 // Get 通过key获取value
 func (g *Group) Get(key string) (cachebackend.Valuer, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 通过key获取value,ctx取消或超时时立即返回ctx.Err(),见load
+func (g *Group) GetContext(ctx context.Context, key string) (cachebackend.Valuer, error) {
 	if key == "" {
 		return nil, fmt.Errorf("key is required")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	// 从本地缓存(一级缓存)中获取数据
 	if v, ok := g.mainCache.Get(key); ok {
-		// 检查数据是否过期
-		if v.Expire() <= time.Now().Unix() {
+		// 检查数据是否过期,到期时间为0表示永不过期
+		if expire := v.Expire(); expire > 0 && expire <= time.Now().Unix() {
 			// 过期删除
-			g.mainCache.Remove(key)
+			g.expire(key)
 		} else {
 			log.Println("[Hit] hit 一级缓存数据", key)
 			return v, nil
@@ -141,61 +237,155 @@ func (g *Group) Get(key string) (cachebackend.Valuer, error) {
 	}
 
 	// 从远程节点中获取
-	return g.load(key)
+	return g.load(ctx, key)
 }
 
 func (g *Group) Set(key string, value cachebackend.Valuer, isLocalCache bool) (newValue cachebackend.Valuer, err error) {
+	return g.SetContext(context.Background(), key, value, isLocalCache)
+}
+
+// SetContext 新增数据,写入节点时遵循ctx的截止时间与取消
+func (g *Group) SetContext(ctx context.Context, key string, value cachebackend.Valuer, isLocalCache bool) (newValue cachebackend.Valuer, err error) {
 	if key == "" {
 		return nil, fmt.Errorf("key is required")
 	}
-	// 写入本地缓存
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// 写入本地缓存,不写入本地缓存时删除本地缓存中的旧值
 	if isLocalCache {
-		newValue = lru.NewValue(value.Bytes(), time.Now().Add(consts.DefaultLocalCacheDuration).Unix(), value.GroupName())
+		newValue = lru.NewValue(value.Bytes(), localExpire(value.Expire()), value.GroupName())
 		g.populateCache(key, newValue)
+	} else {
+		g.mainCache.Remove(key)
 	}
-	if g.nodes != nil {
-		// 存在节点时,从节点获取数据
-		if peer, ok := g.nodes.PickNode(key); ok {
-			if value, err = g.setFromNode(peer, key, value); err == nil {
-				return value, nil
-			}
-			log.Println("[Hit] Failed to get from peer", err)
+	stored, tried, err := g.setToNodes(ctx, key, value)
+	if !tried {
+		return newValue, nil
+	}
+	if err != nil {
+		// 所有节点写入失败
+		return newValue, err
+	}
+	// 至少一个副本写入成功时广播失效事件
+	g.publish(ctx, key)
+	return stored, nil
+}
+
+// Delete 删除key,同时删除本地(一级)缓存与所属节点上的数据
+func (g *Group) Delete(key string) error {
+	return g.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext 删除key,访问节点时遵循ctx的截止时间与取消
+func (g *Group) DeleteContext(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.mainCache.Remove(key)
+	// 删除只发送到key的副本节点,不转移到其他节点:非副本节点上删除成功并不能删除副本上的数据
+	errs := g.replicate(ctx, key, func(ctx context.Context, i int, node backend.Nodor) error {
+		return g.delFromNode(ctx, node, key)
+	})
+	// 至少一个副本删除成功时广播失效事件,任一副本删除失败时返回该错误
+	var firstErr error
+	reached := false
+	for _, err := range errs {
+		if err == nil {
+			reached = true
+		} else if firstErr == nil {
+			firstErr = err
 		}
 	}
-	return newValue, nil
+	if reached {
+		g.publish(ctx, key)
+	}
+	return firstErr
+}
+
+// setToNodes 写入节点.开启副本时写入所有副本,任一副本成功即视为成功;
+// 否则写入主节点,失败时依次尝试下一个节点.没有可用节点时tried为false
+func (g *Group) setToNodes(ctx context.Context, key string, value cachebackend.Valuer) (stored cachebackend.Valuer, tried bool, err error) {
+	if g.replicationFactor() == 1 {
+		tried, err = g.withFailover(ctx, key, func(ctx context.Context, node backend.Nodor) (err error) {
+			stored, err = g.setFromNode(ctx, node, key, value)
+			return err
+		})
+		return stored, tried, err
+	}
+
+	values := make([]cachebackend.Valuer, g.replicationFactor())
+	errs := g.replicate(ctx, key, func(ctx context.Context, i int, node backend.Nodor) (err error) {
+		values[i], err = g.setFromNode(ctx, node, key, value)
+		return err
+	})
+	if len(errs) == 0 {
+		return nil, false, nil
+	}
+	for i, err := range errs {
+		if err == nil {
+			return values[i], true, nil
+		}
+	}
+	return nil, true, errs[0]
 }
 
-// load 当存在节点时,从节点获取数据,否则从本地DB获取数据
-func (g *Group) load(key string) (value cachebackend.Valuer, err error) {
-	do, err := g.loader.Do(key, func() (interface{}, error) {
+// load 当存在节点时,从节点获取数据,否则从本地DB获取数据.
+// 同一key的并发加载共享一次加载,加载使用不随调用方取消、但保留调用方截止时间与值的ctx,
+// 每次访问节点的超时时间见withFailover,所有节点访问失败后通过Getter加载.
+// 调用方ctx取消或超时时仅该调用方返回ctx.Err()
+func (g *Group) load(ctx context.Context, key string) (cachebackend.Valuer, error) {
+	do, err := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+		ctx, cancel := detachContext(ctx)
+		defer cancel()
 		log.Println("[Hit] hit 获取远程节点数据")
-		if g.nodes != nil {
-			// 存在节点时,从节点获取数据
-			if peer, ok := g.nodes.PickNode(key); ok {
-				if value, err = g.getFromNode(peer, key); err == nil {
-					// 克隆一个新值,存入本地(一级)缓存
-					newValue := lru.NewValue(value.Bytes(), time.Now().Add(consts.DefaultLocalCacheDuration).Unix(), value.GroupName())
-					g.populateCache(key, newValue)
-					return value, nil
-				}
-				log.Println("[Hit] Failed to get from peer", err)
-			}
+		// 存在节点时从节点获取数据,失败时依次尝试下一个节点
+		var value cachebackend.Valuer
+		tried, err := g.withFailover(ctx, key, func(ctx context.Context, node backend.Nodor) (err error) {
+			atomic.AddInt64(&g.counters.remoteLoads, 1)
+			value, err = g.getFromNode(ctx, node, key)
+			return err
+		})
+		if tried && err == nil {
+			// 克隆一个新值,存入本地(一级)缓存
+			newValue := lru.NewValue(value.Bytes(), localExpire(value.Expire()), value.GroupName())
+			g.populateCache(key, newValue)
+			return value, nil
 		}
-		return g.getLocally(key)
+		atomic.AddInt64(&g.counters.localLoads, 1)
+		return g.getLocally(ctx, key)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return do.(cachebackend.Valuer), nil
+}
+
+// detachedContext 不随父ctx取消,保留父ctx的值
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+func (c detachedContext) Value(key interface{}) interface{}     { return c.parent.Value(key) }
 
-	if err == nil {
-		return do.(*lru.Value), nil
+// detachContext 返回不随ctx取消的ctx,ctx设置了截止时间时保留该截止时间
+func detachContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := detachedContext{parent: ctx}
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
 	}
-	return
+	return context.WithCancel(detached)
 }
 
 // getFromPeer 从节点获取存储
-func (g *Group) getFromNode(peer backend.NodeGetter, key string) (cachebackend.Valuer, error) {
+func (g *Group) getFromNode(ctx context.Context, peer backend.NodeGetter, key string) (cachebackend.Valuer, error) {
 	// 从节点获取存储
 	in := &pb.GetRequest{Group: g.name, Key: key}
 	out := &pb.GetResponse{}
-	err := peer.Get(in, out)
+	err := peer.Get(ctx, in, out)
 	if err != nil {
 		return &lru.Value{}, err
 	}
@@ -203,23 +393,39 @@ func (g *Group) getFromNode(peer backend.NodeGetter, key string) (cachebackend.V
 }
 
 // getFromPeer 从节点获取存储
-func (g *Group) setFromNode(peer backend.NodeSetter, key string, value cachebackend.Valuer) (cachebackend.Valuer, error) {
+func (g *Group) setFromNode(ctx context.Context, peer backend.NodeSetter, key string, value cachebackend.Valuer) (cachebackend.Valuer, error) {
 	// 从节点获取存储
-	in := &pb.SetRequest{Group: g.name, Key: key, Value: value.Bytes()}
+	ttl, ok := requestTTL(value.Expire(), time.Now().Unix())
+	if !ok {
+		return nil, fmt.Errorf("value of key %s has expired", key)
+	}
+	in := &pb.SetRequest{Group: g.name, Key: key, Value: value.Bytes(), Ttl: ttl}
 	out := &pb.SetResponse{}
-	err := peer.Set(in, out)
+	err := peer.Set(ctx, in, out)
 	if err != nil {
 		return nil, err
 	}
 	return lru.NewValue(out.Data.Value, out.Data.Expire, out.Data.Group), nil
 }
 
-// getFromPeer 从节点获取存储
-func (g *Group) delFromNode(peer backend.NodeDeler, key string) error {
-	// 从节点获取存储
+// requestTTL 根据数据的到期时间计算写入节点的过期时长(秒).到期时间为0时返回0,由节点使用分组的默认过期时长;
+// 小于0时返回-1,表示永不过期;数据已过期时ok为false
+func requestTTL(expire, now int64) (ttl int64, ok bool) {
+	switch {
+	case expire == 0:
+		return 0, true
+	case expire < 0:
+		return -1, true
+	}
+	ttl = expire - now
+	return ttl, ttl > 0
+}
+
+// delFromNode 从节点删除数据
+func (g *Group) delFromNode(ctx context.Context, peer backend.NodeDeler, key string) error {
 	in := &pb.DelRequest{Group: g.name, Key: key}
 	out := &pb.DelResponse{}
-	err := peer.Del(in, out)
+	err := peer.Del(ctx, in, out)
 	if err != nil {
 		return err
 	}
@@ -227,9 +433,9 @@ func (g *Group) delFromNode(peer backend.NodeDeler, key string) error {
 }
 
 // getLocally 从本地DB
-func (g *Group) getLocally(key string) (cachebackend.Valuer, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (cachebackend.Valuer, error) {
 	// 从DB数据中获取
-	value, err := g.getter.Get(key)
+	value, err := g.getLocallyBytes(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -238,7 +444,95 @@ func (g *Group) getLocally(key string) (cachebackend.Valuer, error) {
 	return newValue, nil
 }
 
+// getLocallyBytes 调用Getter加载数据.Getter未实现GetterContext时无法中断加载,
+// 但ctx取消或超时后不再等待其结果
+func (g *Group) getLocallyBytes(ctx context.Context, key string) ([]byte, error) {
+	if getter, ok := g.getter.(GetterContext); ok {
+		return getter.GetContext(ctx, key)
+	}
+	type result struct {
+		value []byte
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := g.getter.Get(key)
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// localExpire 本地(一级)缓存的到期时间,不晚于数据本身的到期时间
+func localExpire(expire int64) int64 {
+	local := time.Now().Add(consts.DefaultLocalCacheDuration).Unix()
+	if expire > 0 && expire < local {
+		return expire
+	}
+	return local
+}
+
 // populateCache 填充数据到缓存中
 func (g *Group) populateCache(key string, value cachebackend.Valuer) {
 	g.mainCache.Add(key, value)
 }
+
+// publish 通知其他实例删除本地缓存中的keys,遵循ctx的截止时间与取消
+func (g *Group) publish(ctx context.Context, keys ...string) {
+	if g.invalidator == nil || len(keys) == 0 {
+		return
+	}
+	if err := g.invalidator.Publish(ctx, g.name, keys); err != nil {
+		log.Println("[Hit] Failed to publish invalidation", err)
+	}
+}
+
+// invalidateLocal 删除本地(一级)缓存中的keys
+func (g *Group) invalidateLocal(keys []string) {
+	for _, key := range keys {
+		g.mainCache.Remove(key)
+	}
+}
+
+// expire 删除过期数据
+func (g *Group) expire(key string) {
+	if expirer, ok := g.mainCache.(cache.KeyExpirer); ok {
+		expirer.Expire(key)
+	} else {
+		g.mainCache.Remove(key)
+	}
+}
+
+// Stats 客户端分组统计
+type Stats struct {
+	cachebackend.Stats       // 本地(一级)缓存统计
+	RemoteLoads        int64 `json:"remote_loads"` // 从远程节点加载次数
+	PeerErrors         int64 `json:"peer_errors"`  // 访问远程节点失败次数
+	LocalLoads         int64 `json:"local_loads"`  // 通过Getter加载次数
+}
+
+// Stats 分组统计
+func (g *Group) Stats() Stats {
+	stats := Stats{
+		RemoteLoads: atomic.LoadInt64(&g.counters.remoteLoads),
+		PeerErrors:  atomic.LoadInt64(&g.counters.peerErrors),
+		LocalLoads:  atomic.LoadInt64(&g.counters.localLoads),
+	}
+	if stater, ok := g.mainCache.(cachebackend.Stater); ok {
+		stats.Stats = stater.Stats()
+	} else {
+		stats.Items = int64(g.mainCache.Len())
+	}
+	return stats
+}
+
+// counters 加载计数,使用原子操作更新
+type counters struct {
+	remoteLoads int64
+	peerErrors  int64
+	localLoads  int64
+}
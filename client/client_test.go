@@ -22,6 +22,7 @@ import (
 	"github.com/chenquan/go-utils/async"
 	"github.com/chenquan/hit/client/hit"
 	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/server"
 	"math/rand"
 	"reflect"
 	"strconv"
@@ -117,3 +118,54 @@ func TestClient(t *testing.T) {
 	})
 	select {}
 }
+
+func TestDelete(t *testing.T) {
+	server.NewGroupDefault("delete", 0)
+	urls, _ := startNodes(t, 1)
+	g := newBatchGroup("delete", newPicker(urls...), func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not found", key)
+	})
+
+	if _, err := g.Set("key", lru.NewValue([]byte("value"), time.Now().Add(time.Minute).Unix(), "delete"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mainCache.Get("key"); ok {
+		t.Fatalf("key still in local cache")
+	}
+	if _, err := server.GetGroup("delete").Get("key"); err == nil {
+		t.Fatalf("key still on node")
+	}
+	if _, err := g.Get("key"); err == nil {
+		t.Fatalf("expected deleted key to be missing")
+	}
+	// 删除不存在的key
+	if err := g.Delete("missing"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticNodes(t *testing.T) {
+	c := newCluster(t, 2)
+	nodes := make(map[string]string)
+	for i, s := range c.servers {
+		nodes["node"+strconv.Itoa(i)] = s.URL
+	}
+	h := NewHit(&hit.Config{Replicas: 3, Nodes: nodes})
+	var f GetterFunc = func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("not found")
+	}
+	g := h.NewGroupDefault("static", "", 1000, f)
+
+	if _, err := g.Set("key", lru.NewValue([]byte("v"), 0, "static"), false); err != nil {
+		t.Fatal(err)
+	}
+	if stored := c.stored("static", "key"); len(stored) != 1 {
+		t.Fatalf("expected key on one node but got %v", stored)
+	}
+	if v, err := g.Get("key"); err != nil || string(v.Bytes()) != "v" {
+		t.Fatalf("unexpected value: %v %v", v, err)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"github.com/chenquan/hit/internal/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"github.com/chenquan/hit/internal/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestContextHungNode(t *testing.T) {
+	// 节点在请求取消前不返回
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer s.Close()
+	defer close(release)
+	g := newBatchGroup("context", newPicker(s.URL+consts.DefaultBasePath), func(key string) ([]byte, error) {
+		return []byte("db"), nil
+	})
+
+	deadline := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 50*time.Millisecond)
+	}
+	start := time.Now()
+	ctx, cancel := deadline()
+	defer cancel()
+	if _, err := g.GetContext(ctx, "k"); err != context.DeadlineExceeded {
+		t.Fatalf("GetContext: expected deadline exceeded but got %v", err)
+	}
+	ctx, cancel = deadline()
+	defer cancel()
+	if _, err := g.SetContext(ctx, "k", lru.NewValue([]byte("v"), 0, "context"), false); err != context.DeadlineExceeded {
+		t.Fatalf("SetContext: expected deadline exceeded but got %v", err)
+	}
+	ctx, cancel = deadline()
+	defer cancel()
+	if err := g.DeleteContext(ctx, "k"); err == nil {
+		t.Fatalf("DeleteContext: expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("calls took %v, deadline was not honoured", elapsed)
+	}
+}
+
+func TestContextGetter(t *testing.T) {
+	newGroup := func(getter Getter) *Group {
+		return &Group{name: "getter", getter: getter, mainCache: cache.NewSyncCacheDefault(0), loader: &utils.Loader{}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// GetterContext 收到的ctx保留调用方的值与截止时间
+	type ctxKey struct{}
+	callerDeadline, _ := ctx.Deadline()
+	g := newGroup(GetterContextFunc(func(getterCtx context.Context, key string) ([]byte, error) {
+		if getterCtx.Value(ctxKey{}) != "caller" {
+			t.Errorf("getter did not receive caller ctx values")
+		}
+		if deadline, ok := getterCtx.Deadline(); !ok || !deadline.Equal(callerDeadline) {
+			t.Errorf("expected getter deadline %v but got %v", callerDeadline, deadline)
+		}
+		<-getterCtx.Done()
+		return nil, getterCtx.Err()
+	}))
+	if _, err := g.GetContext(context.WithValue(ctx, ctxKey{}, "caller"), "k"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded but got %v", err)
+	}
+
+	// 普通Getter超时后不再等待
+	release := make(chan struct{})
+	defer close(release)
+	g = newGroup(GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte("late"), nil
+	}))
+	if _, err := g.GetContext(ctx, "k"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded but got %v", err)
+	}
+
+	// 已取消的ctx直接返回
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newGroup(GetterFunc(func(key string) ([]byte, error) {
+		t.Fatal("getter should not be called")
+		return nil, nil
+	})).GetContext(canceled, "k"); err != context.Canceled {
+		t.Fatalf("expected canceled but got %v", err)
+	}
+}
+
+func TestContextSharedLoad(t *testing.T) {
+	start, release := make(chan struct{}), make(chan struct{})
+	g := &Group{name: "shared", mainCache: cache.NewSyncCacheDefault(0), loader: &utils.Loader{}}
+	g.getter = GetterContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		close(start)
+		select {
+		case <-release:
+			return []byte("db"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+
+	// 首个调用方取消后,等待同一key的其他调用方仍能获取数据
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(ctx, "k")
+		first <- err
+	}()
+	<-start
+	second := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(context.Background(), "k")
+		second <- err
+	}()
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("expected canceled but got %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("expected shared load to succeed but got %v", err)
+	}
+}
+
+func TestContextHungNodeFallback(t *testing.T) {
+	// 节点在请求取消前不返回
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer s.Close()
+	defer close(release)
+	// Getter耗时超过访问节点超时时间,但未超过调用方截止时间
+	g := newBatchGroup("fallback", newPicker(s.URL+consts.DefaultBasePath), func(key string) ([]byte, error) {
+		time.Sleep(100 * time.Millisecond)
+		return []byte("db"), nil
+	})
+	g.timeout = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := g.GetContext(ctx, "k")
+	if err != nil || string(v.Bytes()) != "db" {
+		t.Fatalf("expected value from getter but got %v %v", v, err)
+	}
+	// 调用方未设置截止时间
+	v, err = g.Get("k2")
+	if err != nil || string(v.Bytes()) != "db" {
+		t.Fatalf("expected value from getter but got %v %v", v, err)
+	}
+}
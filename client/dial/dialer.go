@@ -0,0 +1,76 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// 按节点地址的协议创建远程节点,供etcd与静态节点列表等服务发现实现共用
+package dial
+
+import (
+	"github.com/chenquan/hit/client/backend"
+	"github.com/chenquan/hit/client/grpc"
+	"github.com/chenquan/hit/client/hit"
+	"github.com/chenquan/hit/internal/consts"
+	"github.com/chenquan/hit/internal/tlsutil"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Dialer 按节点地址的协议创建远程节点,供各服务发现实现共用
+type Dialer struct {
+	timeout    time.Duration      // 访问节点超时时间
+	httpClient *http.Client       // 访问http/https节点
+	grpcOpts   []ggrpc.DialOption // 访问grpc节点
+}
+
+// NewDialer 按配置创建Dialer,配置了TLS时通过TLS访问节点
+func NewDialer(config *hit.Config) (*Dialer, error) {
+	d := &Dialer{
+		timeout:    config.NodeTimeout(),
+		httpClient: http.DefaultClient,
+		grpcOpts:   []ggrpc.DialOption{ggrpc.WithInsecure()},
+	}
+	if config.TLSEnabled() {
+		tlsConfig, err := tlsutil.ClientConfig(config.CertFile, config.KeyFile, config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		d.httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		d.grpcOpts = []ggrpc.DialOption{ggrpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+	}
+	return d, nil
+}
+
+// Dial 按节点注册时声明的协议创建远程节点,addr形如 http(s)://host:port 或 grpc://host:port.
+// 配置了TLS时,grpc节点同样通过TLS访问
+func (d *Dialer) Dial(addr string) backend.Nodor {
+	if target := strings.TrimPrefix(addr, consts.ProtocolGRPC+"://"); target != addr {
+		return grpc.NewNode(addr, target, d.timeout, d.grpcOpts...)
+	}
+	return NewNodeWithClient(addr+consts.DefaultBasePath, d.httpClient, d.timeout)
+}
+
+// CloseNodeLater 等待consts.DefaultNodeCloseDelay后释放节点持有的连接.
+// 选取节点后的请求不持有锁,节点移除时可能仍在使用该节点
+func CloseNodeLater(node backend.Nodor) {
+	if closer, ok := node.(io.Closer); ok {
+		time.AfterFunc(consts.DefaultNodeCloseDelay, func() {
+			_ = closer.Close()
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package dial
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/chenquan/hit/client/backend"
+	"github.com/chenquan/hit/internal/consts"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 远程节点
+type Node struct {
+	url     string
+	client  *http.Client
+	timeout time.Duration // 调用方未设置截止时间时的默认超时时间
+}
+
+func NewNode(url string) *Node {
+	return NewNodeWithClient(url, http.DefaultClient, consts.DefaultNodeTimeout)
+}
+
+// NewNodeWithClient 使用指定的http.Client访问远程节点,例如配置了TLS的客户端.
+// timeout为调用方未设置截止时间时的默认超时时间,0表示不限制
+func NewNodeWithClient(url string, client *http.Client, timeout time.Duration) *Node {
+	return &Node{url: url, client: client, timeout: timeout}
+}
+
+func (h *Node) Set(ctx context.Context, in *pb.SetRequest, out *pb.SetResponse) error {
+	u := fmt.Sprintf(
+		"%v/%v/%v",
+		h.url,
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+	)
+	if err := h.do(ctx, http.MethodPost, u, in, out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// 从远程节点获取数据
+func (h *Node) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	u := fmt.Sprintf(
+		"%v/%v/%v",
+		h.url,
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+	)
+	if err := h.do(ctx, http.MethodGet, u, nil, out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// 从远程节点删除数据
+func (h *Node) Del(ctx context.Context, in *pb.DelRequest, out *pb.DelResponse) error {
+	u := fmt.Sprintf(
+		"%v/%v/%v",
+		h.url,
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+	)
+	if err := h.do(ctx, http.MethodDelete, u, nil, out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// MultiGet 从远程节点批量获取数据
+func (h *Node) MultiGet(ctx context.Context, in *pb.MultiGetRequest, out *pb.MultiGetResponse) error {
+	if err := h.do(ctx, http.MethodPost, h.batchUrl(in.GetGroup(), consts.BatchGet), in, out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// MultiSet 向远程节点批量新增数据
+func (h *Node) MultiSet(ctx context.Context, in *pb.MultiSetRequest, out *pb.MultiSetResponse) error {
+	if err := h.do(ctx, http.MethodPost, h.batchUrl(in.GetGroup(), consts.BatchSet), in, out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// MultiDel 从远程节点批量删除数据
+func (h *Node) MultiDel(ctx context.Context, in *pb.MultiDelRequest, out *pb.MultiDelResponse) error {
+	if err := h.do(ctx, http.MethodPost, h.batchUrl(in.GetGroup(), consts.BatchDel), in, out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// batchUrl 批量请求地址 /<basepath>/<groupname>?batch=<op>
+func (h *Node) batchUrl(group, op string) string {
+	return fmt.Sprintf(
+		"%v/%v?%v=%v",
+		h.url,
+		url.QueryEscape(group),
+		consts.BatchQuery,
+		op,
+	)
+}
+
+// do 发送请求并解析响应,in为nil时不发送请求体.请求随ctx取消或超时而终止
+func (h *Node) do(ctx context.Context, method, u string, in, out proto.Message) error {
+	if _, ok := ctx.Deadline(); !ok && h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	var body []byte
+	if in != nil {
+		body, _ = proto.Marshal(in)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", consts.ContentType)
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("register returned: %v", res.Status)
+	}
+
+	bytesData, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if err = proto.Unmarshal(bytesData, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// 获取远程节点地址
+func (h *Node) Url() string {
+	return h.url
+}
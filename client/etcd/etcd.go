@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/chenquan/hit/client/backend"
+	"github.com/chenquan/hit/client/dial"
 	"github.com/chenquan/hit/client/hit"
 	"github.com/chenquan/hit/internal/consistenthash"
 	"github.com/chenquan/hit/internal/consts"
@@ -30,6 +31,7 @@ import (
 
 	"os"
 	"sync"
+	"time"
 )
 
 //
@@ -42,8 +44,18 @@ type Client struct {
 	client *clientv3.Client         // etcd客户端
 	peers  *consistenthash.Map      // 存储哈希一致性数据
 	nodes  map[string]backend.Nodor // key 节点名称,节点结构体
+	addrs  map[string]string        // key 节点名称,节点地址
 	lock   sync.RWMutex             // 锁,用于
 	wg     sync.WaitGroup           // 锁,用于关闭etcd client
+
+	id         string           // 客户端实例ID,用于忽略自身发布的失效事件
+	seq        uint64           // 失效事件序号
+	timeout    time.Duration    // 发布失效事件的超时时间,调用方未设置截止时间时使用
+	leaseLock  sync.Mutex       // 保护lease与leaseRenew
+	lease      clientv3.LeaseID // 失效事件绑定的租约
+	leaseRenew time.Time        // 重新申请租约的时间
+
+	dialer *dial.Dialer // 创建远程节点
 }
 
 func NewClient(config *hit.Config) *Client {
@@ -66,7 +78,21 @@ func NewClient(config *hit.Config) *Client {
 		os.Exit(0)
 	}
 
-	return &Client{client: cli, nodes: make(map[string]backend.Nodor), peers: consistenthash.New(config.Replicas, nil)}
+	dialer, err := dial.NewDialer(config)
+	if err != nil {
+		fmt.Println("Error TLS", err)
+		os.Exit(0)
+	}
+
+	return &Client{
+		client:  cli,
+		id:      newInstanceID(),
+		timeout: config.NodeTimeout(),
+		nodes:   make(map[string]backend.Nodor),
+		addrs:   make(map[string]string),
+		peers:   consistenthash.New(config.VirtualNodes(), nil),
+		dialer:  dialer,
+	}
 }
 
 // PullAllNodes 拉取所有节点
@@ -137,8 +163,17 @@ func (c *Client) putNode(name string, addr string) {
 	defer c.wg.Done()
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	addr = addr + consts.DefaultBasePath
-	c.nodes[name] = NewNode(addr)
+	if old, ok := c.nodes[name]; ok {
+		// 地址未变化时(如租约重建后重新注册)保留原节点
+		if c.addrs[name] == addr {
+			return
+		}
+		dial.CloseNodeLater(old)
+		c.peers.Del(name)
+	}
+	node := c.dialer.Dial(addr)
+	c.nodes[name] = node
+	c.addrs[name] = addr
 	c.peers.Add(name)
 	logging.LogAction("PUT", fmt.Sprintf("Node name:%s, addr:%s", name, addr))
 }
@@ -152,8 +187,10 @@ func (c *Client) delNode(name string) {
 
 	value, exist := c.nodes[name]
 	if exist {
+		dial.CloseNodeLater(value)
 		c.peers.Del(name)
 		delete(c.nodes, name)
+		delete(c.addrs, name)
 		logging.LogAction("DELETE", fmt.Sprintf("Node name:%s addr%s", name, value))
 	}
 
@@ -166,6 +203,17 @@ func (c *Client) GetLocalAllNodes() map[string]backend.Nodor {
 	return c.nodes
 }
 
+// GetNodes 获取当前所有节点,key为节点名称,value为节点地址
+func (c *Client) GetNodes() map[string]string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	nodes := make(map[string]string, len(c.addrs))
+	for name, addr := range c.addrs {
+		nodes[name] = addr
+	}
+	return nodes
+}
+
 // Log 记录日志
 func (c *Client) Log(format string, v ...interface{}) {
 	log.Printf("[Hit] %s.", fmt.Sprintf(format, v...))
@@ -183,3 +231,17 @@ func (c *Client) PickNode(key string) (backend.Nodor, bool) {
 	}
 	return nil, false
 }
+
+// PickNodes 沿哈希环为当前key选取至多n个不同的远程节点,用于故障转移
+func (c *Client) PickNodes(key string, n int) []backend.Nodor {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	names := c.peers.GetN(key, n)
+	nodes := make([]backend.Nodor, 0, len(names))
+	for _, name := range names {
+		if node, ok := c.nodes[name]; ok {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
@@ -17,9 +17,42 @@
 package etcd
 
 import (
+	"github.com/chenquan/hit/client/backend"
+	"github.com/chenquan/hit/client/dial"
+	"github.com/chenquan/hit/client/hit"
+	"github.com/chenquan/hit/internal/consistenthash"
 	"testing"
 )
 
 func TestStep(t *testing.T) {
 
 }
+
+func TestPutNode(t *testing.T) {
+	dialer, err := dial.NewDialer(&hit.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		peers:  consistenthash.New(1, nil),
+		nodes:  make(map[string]backend.Nodor),
+		addrs:  make(map[string]string),
+		dialer: dialer,
+	}
+	c.putNode("node1", "http://localhost:2020")
+	node := c.nodes["node1"]
+	// 地址未变化时保留原节点
+	c.putNode("node1", "http://localhost:2020")
+	if c.nodes["node1"] != node {
+		t.Fatalf("expected node to be kept when address is unchanged")
+	}
+	c.putNode("node1", "http://localhost:2021")
+	if c.nodes["node1"] == node || c.addrs["node1"] != "http://localhost:2021" {
+		t.Fatalf("expected node to be replaced when address changes")
+	}
+	// 哈希环中不应残留重复的节点
+	c.delNode("node1")
+	if name := c.peers.Get("key"); name != "" {
+		t.Fatalf("expected empty ring but got %s", name)
+	}
+}
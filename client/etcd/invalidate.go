@@ -0,0 +1,152 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package etcd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/chenquan/hit/internal/consts"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/etcd-io/etcd/clientv3"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 本地缓存失效事件通过etcd广播: 发布者在 DefaultInvalidatePath 下写入绑定租约的事件,
+// 事件随租约到期自动删除,订阅者只关注写入事件,因此etcd中不会残留事件数据.
+
+// invalidation 失效事件
+type invalidation struct {
+	Origin string   `json:"origin"` // 发布者实例ID
+	Group  string   `json:"group"`  // 分组名称
+	Keys   []string `json:"keys"`   // 失效的key
+}
+
+// newInstanceID 生成客户端实例ID
+func newInstanceID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Publish 发布分组中keys失效,ctx未设置截止时间时使用访问节点的超时时间
+func (c *Client) Publish(ctx context.Context, group string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	value, err := json.Marshal(&invalidation{Origin: c.id, Group: group, Keys: keys})
+	if err != nil {
+		return err
+	}
+	lease, err := c.invalidateLease(ctx)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s/%d", consts.DefaultInvalidatePath, c.id, atomic.AddUint64(&c.seq, 1))
+	_, err = c.client.Put(ctx, key, string(value), clientv3.WithLease(lease))
+	return err
+}
+
+// invalidateLease 获取失效事件绑定的租约,租约使用超过一半时长后重新申请
+func (c *Client) invalidateLease(ctx context.Context) (clientv3.LeaseID, error) {
+	c.leaseLock.Lock()
+	defer c.leaseLock.Unlock()
+	if c.lease != 0 && time.Now().Before(c.leaseRenew) {
+		return c.lease, nil
+	}
+	resp, err := c.client.Grant(ctx, int64(consts.DefaultInvalidateTTL/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	c.lease = resp.ID
+	c.leaseRenew = time.Now().Add(consts.DefaultInvalidateTTL / 2)
+	return c.lease, nil
+}
+
+// Subscribe 订阅其他实例发布的失效事件.监听中断后从中断处重新监听,
+// 中断期间的事件已被压缩而无法获取时,以空的group与keys调用handler,表示删除所有本地缓存
+func (c *Client) Subscribe(handler func(group string, keys []string)) {
+	go func() {
+		var rev int64 // 下一个要接收的版本,0表示未知
+		for {
+			rev = c.watchInvalidations(rev, handler)
+			if c.client.Ctx().Err() != nil {
+				// 客户端已关闭
+				return
+			}
+			if rev == 0 {
+				log.Println("[Hit] Lost invalidation events, clear local cache")
+				handler("", nil)
+			}
+			time.Sleep(consts.DefaultInvalidateRetryInterval)
+		}
+	}()
+}
+
+// watchInvalidations 从版本rev(为0时从当前版本)开始监听失效事件直到监听中断,
+// 返回下一个要接收的版本,无法确定时返回0
+func (c *Client) watchInvalidations(rev int64, handler func(group string, keys []string)) int64 {
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithFilterDelete()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev))
+	} else {
+		opts = append(opts, clientv3.WithCreatedNotify())
+	}
+	watchChan := c.client.Watch(context.Background(), consts.DefaultInvalidatePath, opts...)
+	for wc := range watchChan {
+		if wc.CompactRevision != 0 {
+			// 中断期间的事件已被压缩
+			log.Println("[Hit] Invalidation watch compacted", wc.Err())
+			return 0
+		}
+		if err := wc.Err(); err != nil {
+			log.Println("[Hit] Invalidation watch failed", err)
+			return rev
+		}
+		if wc.Created && rev == 0 {
+			rev = wc.Header.Revision + 1
+		}
+		for _, ev := range wc.Events {
+			rev = ev.Kv.ModRevision + 1
+			if ev.Type != mvccpb.PUT {
+				continue
+			}
+			var event invalidation
+			if err := json.Unmarshal(ev.Kv.Value, &event); err != nil {
+				log.Println("[Hit] Invalid invalidation event", err)
+				continue
+			}
+			if event.Origin == c.id {
+				continue
+			}
+			handler(event.Group, event.Keys)
+		}
+	}
+	return rev
+}
@@ -0,0 +1,119 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/chenquan/hit/client/backend"
+	"github.com/chenquan/hit/internal/consts"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 故障转移: 节点访问失败时沿哈希环依次尝试下一个不同的节点,
+// 节点正常响应但操作失败(backend.ResponseError)时仅在副本之间继续尝试.
+// 删除不进行故障转移,见Group.DeleteContext.
+
+// retry 故障转移策略
+type retry struct {
+	attempts int           // 最多尝试的不同节点数,小于1时视为1
+	backoff  time.Duration // 重试下一个节点前的等待时间,逐次翻倍
+}
+
+// withFailover 依次在key的候选节点上执行fn直到成功,候选节点为副本节点及其后继节点.
+// 每次访问节点的超时时间为访问节点超时时间,不超过ctx的截止时间.
+// 副本节点上key不存在时继续读取下一个副本.没有可用节点时tried为false
+func (g *Group) withFailover(ctx context.Context, key string, fn func(ctx context.Context, node backend.Nodor) error) (tried bool, err error) {
+	if g.nodes == nil {
+		return false, nil
+	}
+	attempts := g.retry.attempts
+	if replication := g.replicationFactor(); attempts < replication {
+		attempts = replication
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	nodes := g.nodes.PickNodes(key, attempts)
+	if len(nodes) == 0 {
+		return false, nil
+	}
+
+	backoff := g.retry.backoff
+	nodeFailed := false
+	for i, node := range nodes {
+		// 仅在节点不可用后等待
+		if nodeFailed {
+			if err := sleep(ctx, backoff); err != nil {
+				return true, err
+			}
+			backoff *= 2
+		}
+		if err = g.tryNode(ctx, node, fn); err == nil {
+			return true, nil
+		}
+		atomic.AddInt64(&g.counters.peerErrors, 1)
+		log.Println("[Hit] Failed to access peer", node.Url(), err)
+
+		var responseErr *backend.ResponseError
+		if errors.As(err, &responseErr) {
+			if i >= g.replicationFactor()-1 {
+				return true, err
+			}
+			nodeFailed = false
+			continue
+		}
+		if ctx.Err() != nil {
+			return true, ctx.Err()
+		}
+		nodeFailed = true
+	}
+	return true, err
+}
+
+// tryNode 在访问节点超时时间内执行fn
+func (g *Group) tryNode(ctx context.Context, node backend.Nodor, fn func(ctx context.Context, node backend.Nodor) error) error {
+	ctx, cancel := g.nodeContext(ctx)
+	defer cancel()
+	return fn(ctx, node)
+}
+
+// nodeContext 为单次访问节点设置访问节点超时时间
+func (g *Group) nodeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := g.timeout
+	if timeout <= 0 {
+		timeout = consts.DefaultNodeTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// sleep 等待d,ctx取消或超时时提前返回
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"github.com/chenquan/hit/internal/server"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	server.NewGroupDefault("failover", 0)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	downURL := down.URL + consts.DefaultBasePath
+	urls, counts := startNodes(t, 1)
+	nodes := newPicker(downURL, urls[0])
+
+	// 找到主节点不可用的key
+	var key string
+	for i := 0; ; i++ {
+		key = "key" + strconv.Itoa(i)
+		if node, _ := nodes.PickNode(key); node.Url() == downURL {
+			break
+		}
+	}
+	newGroup := func(attempts int) *Group {
+		g := newBatchGroup("failover", nodes, func(key string) ([]byte, error) {
+			return []byte("db"), nil
+		})
+		g.retry = retry{attempts: attempts, backoff: time.Millisecond}
+		return g
+	}
+	value := lru.NewValue([]byte("v"), time.Now().Add(time.Minute).Unix(), "failover")
+
+	if _, err := newGroup(1).Set(key, value, false); err == nil {
+		t.Fatalf("expected error without failover")
+	}
+
+	g := newGroup(2)
+	if _, err := g.Set(key, value, false); err != nil {
+		t.Fatalf("Set should fail over to the next node: %v", err)
+	}
+	if v, err := newGroup(2).Get(key); err != nil || string(v.Bytes()) != "v" {
+		t.Fatalf("Get should fail over to the next node: %v", err)
+	}
+	if values, errs := newGroup(2).GetMulti([]string{key}); len(errs) != 0 || string(values[key].Bytes()) != "v" {
+		t.Fatalf("GetMulti should fail over to the next node: %v", errs)
+	}
+	if values, _ := newGroup(1).GetMulti([]string{key}); string(values[key].Bytes()) != "db" {
+		t.Fatalf("expected GetMulti without failover to fall back to Getter")
+	}
+	// 删除不转移到非副本节点,返回主节点的错误
+	if err := g.Delete(key); err == nil {
+		t.Fatalf("Delete should not fail over to the next node")
+	}
+
+	// 节点正常响应但key不存在时不重试
+	var other string
+	for i := 0; ; i++ {
+		other = "other" + strconv.Itoa(i)
+		if node, _ := nodes.PickNode(other); node.Url() == urls[0] {
+			break
+		}
+	}
+	before := atomic.LoadInt64(counts[0])
+	if v, err := g.Get(other); err != nil || string(v.Bytes()) != "db" {
+		t.Fatalf("expected fallback to Getter: %v", err)
+	}
+	if n := atomic.LoadInt64(counts[0]) - before; n != 1 {
+		t.Fatalf("expected a single request but got %d", n)
+	}
+	if stats := g.Stats(); stats.PeerErrors == 0 {
+		t.Fatalf("expected peer errors to be counted")
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// gRPC传输的客户端节点,实现backend.Nodor
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/chenquan/hit/client/backend"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"sync"
+	"time"
+)
+
+// Node 通过gRPC访问的远程节点
+type Node struct {
+	url     string        // 节点地址,形如 grpc://host:port
+	target  string        // 拨号地址,形如 host:port
+	timeout time.Duration // 调用方未设置截止时间时的默认超时时间
+	opts    []grpc.DialOption
+
+	mu     sync.Mutex // protects conn, client, closed
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+	closed bool
+}
+
+// NewNode 创建gRPC远程节点,target为host:port,首次请求时建立连接.
+// timeout为调用方未设置截止时间时的默认超时时间,0表示不限制
+func NewNode(url, target string, timeout time.Duration, opts ...grpc.DialOption) *Node {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	return &Node{url: url, target: target, timeout: timeout, opts: opts}
+}
+
+// withTimeout ctx未设置截止时间时使用默认超时时间
+func (n *Node) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || n.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, n.timeout)
+}
+
+// groupCacheClient 获取(惰性创建)gRPC客户端,节点关闭后返回错误
+func (n *Node) groupCacheClient() (pb.GroupCacheClient, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil, fmt.Errorf("node %s closed", n.url)
+	}
+	if n.client == nil {
+		conn, err := grpc.Dial(n.target, n.opts...)
+		if err != nil {
+			return nil, err
+		}
+		n.conn = conn
+		n.client = pb.NewGroupCacheClient(conn)
+	}
+	return n.client, nil
+}
+
+// 从远程节点获取数据
+func (n *Node) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	client, err := n.groupCacheClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+	res, err := client.Get(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+func (n *Node) Set(ctx context.Context, in *pb.SetRequest, out *pb.SetResponse) error {
+	client, err := n.groupCacheClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+	res, err := client.Set(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+func (n *Node) Del(ctx context.Context, in *pb.DelRequest, out *pb.DelResponse) error {
+	client, err := n.groupCacheClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+	res, err := client.Del(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// MultiGet 从远程节点批量获取数据
+func (n *Node) MultiGet(ctx context.Context, in *pb.MultiGetRequest, out *pb.MultiGetResponse) error {
+	client, err := n.groupCacheClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+	res, err := client.MultiGet(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// MultiSet 向远程节点批量新增数据
+func (n *Node) MultiSet(ctx context.Context, in *pb.MultiSetRequest, out *pb.MultiSetResponse) error {
+	client, err := n.groupCacheClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+	res, err := client.MultiSet(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// MultiDel 从远程节点批量删除数据
+func (n *Node) MultiDel(ctx context.Context, in *pb.MultiDelRequest, out *pb.MultiDelResponse) error {
+	client, err := n.groupCacheClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := n.withTimeout(ctx)
+	defer cancel()
+	res, err := client.MultiDel(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	if !out.Success {
+		return &backend.ResponseError{Message: out.Message}
+	}
+	return nil
+}
+
+// 获取远程节点地址
+func (n *Node) Url() string {
+	return n.url
+}
+
+// Close 关闭连接
+func (n *Node) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	if n.conn != nil {
+		return n.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"github.com/chenquan/hit/client/backend"
+	igrpc "github.com/chenquan/hit/internal/grpc"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/chenquan/hit/internal/server"
+	"net"
+	"testing"
+	"time"
+)
+
+var _ backend.Nodor = (*Node)(nil)
+
+func TestNode(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := igrpc.NewServer()
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+
+	server.NewGroupDefault("grpc", 0)
+	node := NewNode("grpc://"+lis.Addr().String(), lis.Addr().String(), time.Second)
+	ctx := context.Background()
+	defer node.Close()
+
+	setOut := &pb.SetResponse{}
+	if err := node.Set(ctx, &pb.SetRequest{Group: "grpc", Key: "k", Value: []byte("v"), Ttl: -1}, setOut); err != nil {
+		t.Fatal(err)
+	}
+	getOut := &pb.GetResponse{}
+	if err := node.Get(ctx, &pb.GetRequest{Group: "grpc", Key: "k"}, getOut); err != nil || string(getOut.Data.Value) != "v" {
+		t.Fatalf("get: %v %v", err, getOut)
+	}
+
+	multiOut := &pb.MultiGetResponse{}
+	if err := node.MultiGet(ctx, &pb.MultiGetRequest{Group: "grpc", Keys: []string{"k", "missing"}}, multiOut); err != nil {
+		t.Fatal(err)
+	}
+	if len(multiOut.Results) != 2 || !multiOut.Results[0].Success || multiOut.Results[1].Success {
+		t.Fatalf("unexpected results %v", multiOut.Results)
+	}
+
+	delOut := &pb.DelResponse{}
+	if err := node.Del(ctx, &pb.DelRequest{Group: "grpc", Key: "k"}, delOut); err != nil || !delOut.Success {
+		t.Fatalf("del: %v %v", err, delOut)
+	}
+	if err := node.Get(ctx, &pb.GetRequest{Group: "grpc", Key: "k"}, &pb.GetResponse{}); err == nil {
+		t.Fatalf("expected key to be deleted")
+	}
+
+	// 关闭后不再建立连接
+	_ = node.Close()
+	if err := node.Get(ctx, &pb.GetRequest{Group: "grpc", Key: "k"}, &pb.GetResponse{}); err == nil {
+		t.Fatalf("expected error after close")
+	}
+}
@@ -16,7 +16,70 @@
 
 package hit
 
+import (
+	"github.com/chenquan/hit/internal/consts"
+	"time"
+)
+
 type Config struct {
 	Endpoints []string `json:"endpoints"` // etcd服务节点
-	Replicas  int      `json:"replicas"`  // 虚拟节点个数
+	Replicas  int      `json:"replicas"`  // 虚拟节点个数.默认:10
+	Timeout   int64    `json:"timeout"`   // 访问节点超时时间(毫秒),调用方未设置截止时间时使用.默认:3000
+	Attempts  int      `json:"attempts"`  // 访问节点失败时沿哈希环最多尝试的不同节点数,1表示不重试.默认:1
+	Backoff   int64    `json:"backoff"`   // 重试下一个节点前的等待时间(毫秒),逐次翻倍.默认:20
+
+	// 不使用etcd时的节点发现,优先使用Nodes
+	Nodes     map[string]string `json:"nodes"`      // 静态节点列表,key为节点名称,value为节点地址,例如 http://localhost:2020
+	NodesFile string            `json:"nodes_file"` // 节点列表文件(JSON或TOML),文件变化时自动更新节点
+
+	Replication int `json:"replication"` // 副本数,key存储在哈希环上的前Replication个不同节点.默认:1
+
+	CertFile string `json:"cert_file"` // 客户端证书,节点开启mTLS时使用
+	KeyFile  string `json:"key_file"`  // 客户端证书私钥
+	CAFile   string `json:"ca_file"`   // 集群CA证书,用于校验节点证书
+}
+
+// VirtualNodes 每个节点在哈希环上的虚拟节点个数
+func (c *Config) VirtualNodes() int {
+	if c.Replicas <= 0 {
+		return consts.DefaultVirtualNodes
+	}
+	return c.Replicas
+}
+
+// NodeTimeout 访问节点超时时间
+func (c *Config) NodeTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return consts.DefaultNodeTimeout
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+// NodeAttempts 访问节点失败时最多尝试的不同节点数
+func (c *Config) NodeAttempts() int {
+	if c.Attempts <= 0 {
+		return consts.DefaultNodeAttempts
+	}
+	return c.Attempts
+}
+
+// NodeBackoff 重试下一个节点前的等待时间
+func (c *Config) NodeBackoff() time.Duration {
+	if c.Backoff <= 0 {
+		return consts.DefaultNodeBackoff
+	}
+	return time.Duration(c.Backoff) * time.Millisecond
+}
+
+// ReplicationFactor 副本数
+func (c *Config) ReplicationFactor() int {
+	if c.Replication <= 0 {
+		return 1
+	}
+	return c.Replication
+}
+
+// TLSEnabled 是否配置了TLS
+func (c *Config) TLSEnabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.CAFile != ""
 }
@@ -0,0 +1,185 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"github.com/chenquan/hit/internal/server"
+	"testing"
+	"time"
+)
+
+// bus 进程内的失效事件广播,每个实例持有一个订阅者
+type bus struct {
+	subscribers []*busInvalidator
+}
+
+type busInvalidator struct {
+	bus     *bus
+	handler func(group string, keys []string)
+}
+
+func (b *bus) join() *busInvalidator {
+	i := &busInvalidator{bus: b}
+	b.subscribers = append(b.subscribers, i)
+	return i
+}
+
+func (i *busInvalidator) Publish(ctx context.Context, group string, keys []string) error {
+	for _, s := range i.bus.subscribers {
+		if s != i && s.handler != nil {
+			s.handler(group, keys)
+		}
+	}
+	return nil
+}
+
+func (i *busInvalidator) Subscribe(handler func(group string, keys []string)) {
+	i.handler = handler
+}
+
+func TestInvalidate(t *testing.T) {
+	server.NewGroupDefault("invalidate", 0)
+	urls, _ := startNodes(t, 1)
+	nodes := newPicker(urls...)
+	b := &bus{}
+
+	newInstance := func() *Group {
+		h := &Hit{groups: make(map[string]*Group)}
+		g := newBatchGroup("invalidate", nodes, func(key string) ([]byte, error) {
+			return []byte("db"), nil
+		})
+		g.invalidator = b.join()
+		g.invalidator.Subscribe(h.invalidate)
+		h.groups[g.name] = g
+		return g
+	}
+	writer, reader := newInstance(), newInstance()
+
+	set := func(g *Group, key, value string) {
+		v := lru.NewValue([]byte(value), time.Now().Add(time.Minute).Unix(), "invalidate")
+		if _, err := g.Set(key, v, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(g *Group, key string) string {
+		v, err := g.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(v.Bytes())
+	}
+
+	set(writer, "k", "v1")
+	if v := get(reader, "k"); v != "v1" {
+		t.Fatalf("expected v1 but got %s", v)
+	}
+	// reader的本地缓存中已有v1,writer修改后reader应立即读到新值
+	set(writer, "k", "v2")
+	if v := get(reader, "k"); v != "v2" {
+		t.Fatalf("expected v2 after invalidation but got %s", v)
+	}
+
+	set(writer, "a", "1")
+	set(writer, "b", "2")
+	reader.GetMulti([]string{"a", "b"})
+	writer.SetMulti(map[string]cachebackend.Valuer{
+		"a": lru.NewValue([]byte("10"), 0, "invalidate"),
+		"b": lru.NewValue([]byte("20"), 0, "invalidate"),
+	}, true)
+	if values, _ := reader.GetMulti([]string{"a", "b"}); string(values["a"].Bytes()) != "10" || string(values["b"].Bytes()) != "20" {
+		t.Fatalf("expected batch write to invalidate reader")
+	}
+
+	if err := writer.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reader.mainCache.Get("k"); ok {
+		t.Fatalf("expected delete to invalidate reader")
+	}
+}
+
+func TestInvalidateAll(t *testing.T) {
+	h := &Hit{groups: make(map[string]*Group)}
+	for _, name := range []string{"a", "b"} {
+		g := newBatchGroup(name, newPicker(), func(key string) ([]byte, error) {
+			return []byte("db"), nil
+		})
+		g.mainCache.Add("k", lru.NewValue([]byte("v"), 0, name))
+		h.groups[name] = g
+	}
+	// 可能丢失了失效事件时删除所有分组的本地缓存
+	h.invalidate("", nil)
+	for name, g := range h.groups {
+		if g.mainCache.Len() != 0 {
+			t.Fatalf("expected local cache of %s to be cleared", name)
+		}
+	}
+}
+
+// recordInvalidator 记录发布的失效事件
+type recordInvalidator struct {
+	keys []string
+}
+
+func (r *recordInvalidator) Publish(ctx context.Context, group string, keys []string) error {
+	r.keys = append(r.keys, keys...)
+	return nil
+}
+
+func (r *recordInvalidator) Subscribe(handler func(group string, keys []string)) {}
+
+func TestInvalidatePartial(t *testing.T) {
+	server.NewGroupDefault("invalidate-partial", 0)
+	urls, _ := startNodes(t, 1)
+	g := newBatchGroup("invalidate-partial", newPicker(urls[0], "http://127.0.0.1:1"+consts.DefaultBasePath), func(key string) ([]byte, error) {
+		return []byte("db"), nil
+	})
+	g.replication = 2
+	r := &recordInvalidator{}
+	g.invalidator = r
+
+	// 部分副本失败时仍返回错误,但已写入或删除的副本需要广播失效事件
+	g.mainCache.Add("k", lru.NewValue([]byte("old"), 0, "invalidate-partial"))
+	if _, err := g.Set("k", lru.NewValue([]byte("v"), 0, "invalidate-partial"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mainCache.Get("k"); ok {
+		t.Fatalf("expected old local value to be removed")
+	}
+	if err := g.Delete("k"); err == nil {
+		t.Fatalf("expected error from failed replica")
+	}
+	if errs := g.DelMulti([]string{"a"}); errs["a"] == nil {
+		t.Fatalf("expected error from failed replica")
+	}
+	if len(r.keys) != 3 || r.keys[0] != "k" || r.keys[1] != "k" || r.keys[2] != "a" {
+		t.Fatalf("unexpected published keys %v", r.keys)
+	}
+
+	// 没有节点时不广播
+	g = newBatchGroup("invalidate-partial", nil, nil)
+	g.invalidator = r
+	_ = g.Delete("k")
+	_ = g.DelMulti([]string{"a"})
+	if len(r.keys) != 3 {
+		t.Fatalf("unexpected published keys %v", r.keys)
+	}
+}
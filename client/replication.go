@@ -0,0 +1,64 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"github.com/chenquan/hit/client/backend"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// 副本: key存储在哈希环上的前N个不同节点,写入与删除并发发送到所有副本,
+// 读取时先读主节点,失败或不存在时依次读取其余副本.
+
+// replicationFactor 副本数,至少为1
+func (g *Group) replicationFactor() int {
+	if g.replication < 1 {
+		return 1
+	}
+	return g.replication
+}
+
+// replicate 在key的所有副本节点上并发执行fn,返回各副本的错误,顺序与副本顺序一致.
+// 每个副本的超时时间见withFailover.没有可用节点时返回nil
+func (g *Group) replicate(ctx context.Context, key string, fn func(ctx context.Context, i int, node backend.Nodor) error) []error {
+	if g.nodes == nil {
+		return nil
+	}
+	nodes := g.nodes.PickNodes(key, g.replicationFactor())
+	if len(nodes) == 0 {
+		return nil
+	}
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node backend.Nodor) {
+			defer wg.Done()
+			ctx, cancel := g.nodeContext(ctx)
+			defer cancel()
+			if errs[i] = fn(ctx, i, node); errs[i] != nil {
+				atomic.AddInt64(&g.counters.peerErrors, 1)
+				log.Println("[Hit] Failed to access replica", node.Url(), errs[i])
+			}
+		}(i, node)
+	}
+	wg.Wait()
+	return errs
+}
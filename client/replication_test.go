@@ -0,0 +1,181 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package client
+
+import (
+	"errors"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"github.com/chenquan/hit/internal/server"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// cluster 进程内的多个节点,每个节点使用独立的分组集合
+type cluster struct {
+	servers []*httptest.Server
+	groups  map[string]*server.Groups // key为节点地址
+	picker  *picker
+}
+
+func newCluster(t *testing.T, n int) *cluster {
+	c := &cluster{groups: make(map[string]*server.Groups)}
+	var urls []string
+	for i := 0; i < n; i++ {
+		groups := server.NewGroups()
+		s := httptest.NewServer(server.NewHTTPPoolWithGroups(groups))
+		t.Cleanup(s.Close)
+		url := s.URL + consts.DefaultBasePath
+		c.servers = append(c.servers, s)
+		c.groups[url] = groups
+		urls = append(urls, url)
+	}
+	c.picker = newPicker(urls...)
+	return c
+}
+
+// stored 返回存有key的节点地址
+func (c *cluster) stored(group, key string) map[string]bool {
+	nodes := make(map[string]bool)
+	for url, groups := range c.groups {
+		if g := groups.GetGroup(group); g != nil {
+			if _, err := g.Get(key); err == nil {
+				nodes[url] = true
+			}
+		}
+	}
+	return nodes
+}
+
+// replicas 返回key的副本节点地址
+func (c *cluster) replicas(key string, n int) []string {
+	var urls []string
+	for _, node := range c.picker.PickNodes(key, n) {
+		urls = append(urls, node.Url())
+	}
+	return urls
+}
+
+// stop 停止节点
+func (c *cluster) stop(url string) {
+	for _, s := range c.servers {
+		if s.URL+consts.DefaultBasePath == url {
+			s.Close()
+		}
+	}
+}
+
+func newReplicatedGroup(c *cluster, replication int) *Group {
+	g := newBatchGroup("replication", c.picker, func(key string) ([]byte, error) {
+		return nil, errors.New("not in db")
+	})
+	g.replication = replication
+	g.retry = retry{attempts: 1}
+	return g
+}
+
+func TestReplication(t *testing.T) {
+	c := newCluster(t, 3)
+	g := newReplicatedGroup(c, 2)
+	value := lru.NewValue([]byte("v"), time.Now().Add(time.Minute).Unix(), "replication")
+
+	if _, err := g.Set("key", value, false); err != nil {
+		t.Fatal(err)
+	}
+	replicas := c.replicas("key", 2)
+	stored := c.stored("replication", "key")
+	if len(stored) != 2 || !stored[replicas[0]] || !stored[replicas[1]] {
+		t.Fatalf("expected key on %v but got %v", replicas, stored)
+	}
+
+	// 主节点重启后数据丢失,从副本读取
+	primary := c.groups[replicas[0]].GetGroup("replication")
+	_ = primary.Delete("key")
+	if v, err := newReplicatedGroup(c, 2).Get("key"); err != nil || string(v.Bytes()) != "v" {
+		t.Fatalf("expected read from replica after primary lost the key: %v", err)
+	}
+
+	// 主节点不可用,从副本读取
+	c.stop(replicas[0])
+	if v, err := newReplicatedGroup(c, 2).Get("key"); err != nil || string(v.Bytes()) != "v" {
+		t.Fatalf("expected read from replica while primary is down: %v", err)
+	}
+	// 单副本时主节点不可用则无法读取
+	if _, err := newReplicatedGroup(c, 1).Get("key"); err == nil {
+		t.Fatalf("expected error without replication")
+	}
+}
+
+func TestReplicationDelete(t *testing.T) {
+	c := newCluster(t, 3)
+	g := newReplicatedGroup(c, 3)
+	value := lru.NewValue([]byte("v"), 0, "replication")
+	if _, err := g.Set("key", value, true); err != nil {
+		t.Fatal(err)
+	}
+	if stored := c.stored("replication", "key"); len(stored) != 3 {
+		t.Fatalf("expected key on all nodes but got %v", stored)
+	}
+	if err := g.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if stored := c.stored("replication", "key"); len(stored) != 0 {
+		t.Fatalf("expected key to be deleted from all replicas but got %v", stored)
+	}
+}
+
+func TestReplicationBatch(t *testing.T) {
+	c := newCluster(t, 3)
+	g := newReplicatedGroup(c, 2)
+
+	values := make(map[string]cachebackend.Valuer)
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		values[key] = lru.NewValue([]byte(key), 0, "replication")
+	}
+	if _, errs := g.SetMulti(values, false); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for _, key := range keys {
+		replicas := c.replicas(key, 2)
+		stored := c.stored("replication", key)
+		if len(stored) != 2 || !stored[replicas[0]] || !stored[replicas[1]] {
+			t.Fatalf("key %s: expected on %v but got %v", key, replicas, stored)
+		}
+		// 删除主节点上的数据
+		_ = c.groups[replicas[0]].GetGroup("replication").Delete(key)
+	}
+
+	got, errs := newReplicatedGroup(c, 2).GetMulti(keys)
+	if len(errs) != 0 || len(got) != len(keys) {
+		t.Fatalf("expected all keys from replicas, got %d values, errors %v", len(got), errs)
+	}
+
+	if errs := g.DelMulti(keys); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for _, key := range keys {
+		if stored := c.stored("replication", key); len(stored) != 0 {
+			t.Fatalf("key %s still stored on %v", key, stored)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package static
+
+import (
+	"encoding/json"
+	"github.com/BurntSushi/toml"
+	"github.com/chenquan/hit/client/hit"
+	"github.com/chenquan/hit/internal/consts"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// nodesFile 节点列表文件,例如:
+//
+//	{"nodes": {"node1": "http://localhost:2020"}}
+//
+// 或TOML:
+//
+//	[nodes]
+//	node1 = "http://localhost:2020"
+type nodesFile struct {
+	Nodes map[string]string `json:"nodes" toml:"nodes"`
+}
+
+// NewFileClient 使用config.NodesFile中的节点,并定时检查文件,文件变化时更新节点.
+// 扩展名为.toml时按TOML解析,否则按JSON解析
+func NewFileClient(config *hit.Config) (*Client, error) {
+	return newFileClient(config, consts.DefaultNodesFileInterval)
+}
+
+func newFileClient(config *hit.Config, interval time.Duration) (*Client, error) {
+	c, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	nodes, modTime, err := readNodesFile(config.NodesFile)
+	if err != nil {
+		return nil, err
+	}
+	c.SetNodes(nodes)
+	go c.watchFile(config.NodesFile, modTime, interval)
+	return c, nil
+}
+
+// watchFile 每隔interval检查文件修改时间,变化时重新加载节点.加载失败时保留原有节点
+func (c *Client) watchFile(path string, modTime time.Time, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			nodes, mt, err := readNodesFile(path)
+			if err != nil {
+				log.Println("[Hit] load nodes file:", err)
+				continue
+			}
+			modTime = mt
+			c.SetNodes(nodes)
+		}
+	}
+}
+
+// readNodesFile 读取节点列表文件及其修改时间
+func readNodesFile(path string) (map[string]string, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var file nodesFile
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &file)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	return file.Nodes, info.ModTime(), err
+}
@@ -0,0 +1,159 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// 不依赖etcd的服务发现:静态节点列表与节点列表文件
+
+package static
+
+import (
+	"context"
+	"fmt"
+	"github.com/chenquan/hit/client/backend"
+	"github.com/chenquan/hit/client/dial"
+	"github.com/chenquan/hit/client/hit"
+	"github.com/chenquan/hit/internal/consistenthash"
+	"github.com/chenquan/hit/internal/logging"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var _ backend.Client = (*Client)(nil)
+
+// Client 基于固定节点列表的服务发现,节点列表可通过SetNodes整体替换.
+// 没有etcd时无法在客户端实例之间广播本地缓存失效事件,Publish与Subscribe不做处理
+type Client struct {
+	dialer *dial.Dialer
+
+	lock  sync.RWMutex
+	peers *consistenthash.Map      // 存储哈希一致性数据
+	nodes map[string]backend.Nodor // key 节点名称
+	addrs map[string]string        // key 节点名称,节点地址
+
+	stop     chan struct{} // 关闭时停止监听节点列表文件
+	stopOnce sync.Once
+}
+
+// NewClient 使用config.Nodes中的节点
+func NewClient(config *hit.Config) (*Client, error) {
+	dialer, err := dial.NewDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		dialer: dialer,
+		peers:  consistenthash.New(config.VirtualNodes(), nil),
+		nodes:  make(map[string]backend.Nodor),
+		addrs:  make(map[string]string),
+		stop:   make(chan struct{}),
+	}
+	c.SetNodes(config.Nodes)
+	return c, nil
+}
+
+// SetNodes 替换节点列表,key为节点名称,value为节点地址.地址未变化的节点保持原有连接.
+// 移除的节点等待处理中的请求完成后再关闭连接
+func (c *Client) SetNodes(nodes map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for name, addr := range c.addrs {
+		if nodes[name] != addr {
+			dial.CloseNodeLater(c.nodes[name])
+			c.peers.Del(name)
+			delete(c.nodes, name)
+			delete(c.addrs, name)
+			logging.LogAction("DELETE", fmt.Sprintf("Node name:%s addr%s", name, addr))
+		}
+	}
+	for name, addr := range nodes {
+		if _, ok := c.addrs[name]; !ok {
+			c.nodes[name] = c.dialer.Dial(addr)
+			c.addrs[name] = addr
+			c.peers.Add(name)
+			logging.LogAction("PUT", fmt.Sprintf("Node name:%s, addr:%s", name, addr))
+		}
+	}
+}
+
+// PullAllNodes 获取所有节点地址
+func (c *Client) PullAllNodes() ([]string, error) {
+	return c.PullNodes("")
+}
+
+// PullNodes 获取名称以prefix开头的节点地址.所有节点始终参与选取
+func (c *Client) PullNodes(prefix string) ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	addrs := make([]string, 0)
+	for name, addr := range c.addrs {
+		if strings.HasPrefix(name, prefix) {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// GetNodes 获取当前所有节点,key为节点名称,value为节点地址
+func (c *Client) GetNodes() map[string]string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	nodes := make(map[string]string, len(c.addrs))
+	for name, addr := range c.addrs {
+		nodes[name] = addr
+	}
+	return nodes
+}
+
+// Close 停止监听节点列表文件并释放节点连接
+func (c *Client) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	c.SetNodes(nil)
+}
+
+// PickNode 为当前key选取一个合适的远程节点
+func (c *Client) PickNode(key string) (backend.Nodor, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if name := c.peers.Get(key); name != "" {
+		return c.nodes[name], true
+	}
+	return nil, false
+}
+
+// PickNodes 沿哈希环为当前key选取至多n个不同的远程节点
+func (c *Client) PickNodes(key string, n int) []backend.Nodor {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	names := c.peers.GetN(key, n)
+	nodes := make([]backend.Nodor, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, c.nodes[name])
+	}
+	return nodes
+}
+
+// Publish 没有etcd时不广播失效事件
+func (c *Client) Publish(ctx context.Context, group string, keys []string) error {
+	return nil
+}
+
+// Subscribe 没有etcd时不会收到其他实例的失效事件
+func (c *Client) Subscribe(handler func(group string, keys []string)) {
+}
@@ -0,0 +1,128 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package static
+
+import (
+	"github.com/chenquan/hit/client/hit"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	c, err := NewClient(&hit.Config{
+		Replicas: 3,
+		Nodes: map[string]string{
+			"node1": "http://localhost:2020",
+			"node2": "grpc://localhost:2021",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if nodes := c.PickNodes("key", 3); len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes but got %d", len(nodes))
+	}
+	if addrs, _ := c.PullNodes("node2"); !reflect.DeepEqual(addrs, []string{"grpc://localhost:2021"}) {
+		t.Fatalf("unexpected nodes %v", addrs)
+	}
+
+	c.SetNodes(map[string]string{"node2": "grpc://localhost:2021", "node3": "http://localhost:2022"})
+	expect := map[string]string{"node2": "grpc://localhost:2021", "node3": "http://localhost:2022"}
+	if nodes := c.GetNodes(); !reflect.DeepEqual(nodes, expect) {
+		t.Fatalf("expected %v but got %v", expect, nodes)
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		node, ok := c.PickNode(key)
+		if !ok || node.Url() == "http://localhost:2020/hit" {
+			t.Fatalf("removed node should not be picked")
+		}
+	}
+
+	c.SetNodes(nil)
+	if _, ok := c.PickNode("key"); ok {
+		t.Fatalf("expected no node")
+	}
+}
+
+func TestFileClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hit-nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := map[string][]string{
+		"nodes.json": {
+			`{"nodes": {"node1": "http://localhost:2020"}}`,
+			`{"nodes": {"node1": "http://localhost:2020", "node2": "http://localhost:2021"}}`,
+		},
+		"nodes.toml": {
+			"[nodes]\nnode1 = \"http://localhost:2020\"\n",
+			"[nodes]\nnode1 = \"http://localhost:2020\"\nnode2 = \"http://localhost:2021\"\n",
+		},
+	}
+	for name, contents := range testCases {
+		path := filepath.Join(dir, name)
+		_ = ioutil.WriteFile(path, []byte(contents[0]), 0644)
+		c, err := newFileClient(&hit.Config{Replicas: 3, NodesFile: path}, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes := c.GetNodes(); len(nodes) != 1 || nodes["node1"] != "http://localhost:2020" {
+			t.Fatalf("%s: unexpected nodes %v", name, nodes)
+		}
+
+		// 文件格式错误时保留原有节点
+		_ = ioutil.WriteFile(path, []byte("{"), 0644)
+		time.Sleep(50 * time.Millisecond)
+		if nodes := c.GetNodes(); len(nodes) != 1 {
+			t.Fatalf("%s: expected nodes to be kept but got %v", name, nodes)
+		}
+
+		_ = ioutil.WriteFile(path, []byte(contents[1]), 0644)
+		deadline := time.Now().Add(time.Second)
+		for len(c.GetNodes()) != 2 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if nodes := c.GetNodes(); len(nodes) != 2 {
+			t.Fatalf("%s: expected nodes to be reloaded but got %v", name, nodes)
+		}
+		c.Close()
+	}
+
+	if _, err := NewFileClient(&hit.Config{NodesFile: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestClientDefaultReplicas(t *testing.T) {
+	// 未配置虚拟节点个数时使用默认值
+	c, err := NewClient(&hit.Config{Nodes: map[string]string{"node1": "http://localhost:2020"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.PickNode("key"); !ok {
+		t.Fatalf("expected a node with default replicas")
+	}
+}
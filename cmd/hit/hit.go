@@ -17,15 +17,26 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/chenquan/hit/internal/consts"
+	"github.com/chenquan/hit/internal/grpc"
+	"github.com/chenquan/hit/internal/metrics"
 	"github.com/chenquan/hit/internal/register"
 	"github.com/chenquan/hit/internal/server"
+	"github.com/chenquan/hit/internal/tlsutil"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -33,19 +44,72 @@ func main() {
 	flag.StringVar(&path, "path", "hit.toml", "配置文件地址")
 	flag.Parse()
 	config := handleConfig(path)
+	applyGroups(config)
+	applySnapshot(config)
+	applyAppendLog(config)
 
-	// 注册节点
 	serverRegister := register.New(config)
+	deregister := func() error {
+		defer serverRegister.Close()
+		return serverRegister.RevokeLease()
+	}
+	// 先监听端口再注册节点,避免客户端在节点接受连接前选取该节点
+	n, err := newNode(config, serverRegister)
+	if err != nil {
+		log.Println(err)
+		_ = deregister()
+		os.Exit(1)
+	}
+	// 注册节点
 	addr := fmt.Sprintf("%s://%s:%s", config.Protocol, config.NodeAddr, config.Port)
+	if err := serverRegister.RegisterNode(config.NodeName, addr); err != nil {
+		log.Println("注册节点失败:", err)
+		_ = deregister()
+		os.Exit(1)
+	}
 
-	_ = serverRegister.RegisterNode(config.NodeName, addr)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	run(n, quit, deregister, time.Duration(config.ShutdownTimeout)*time.Second)
+}
 
-	switch config.Protocol {
-	case consts.ProtocolHTTP:
-		httpPool := server.NewHTTPPool()
-		_ = http.ListenAndServe(":"+config.Port, httpPool)
+// newNode 按协议创建节点服务并监听端口
+func newNode(config *register.Config, serverRegister *register.Server) (node, error) {
+	var tlsConfig *tls.Config
+	if config.CertFile != "" && config.Protocol != consts.ProtocolHTTP {
+		var err error
+		if tlsConfig, err = tlsutil.ServerConfig(config.CertFile, config.KeyFile, config.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	lis, err := net.Listen("tcp", ":"+config.Port)
+	if err != nil {
+		return nil, err
+	}
+	m := metrics.New(serverRegister)
+	if config.Protocol == consts.ProtocolGRPC {
+		opts := []ggrpc.ServerOption{ggrpc.UnaryInterceptor(m.UnaryServerInterceptor())}
+		if tlsConfig != nil {
+			opts = append(opts, ggrpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		n := &grpcNode{srv: grpc.NewServer(opts...), lis: lis}
+		if config.MetricsPort != "" {
+			mux := http.NewServeMux()
+			mux.Handle(consts.DefaultMetricsPath, m)
+			n.metrics = &http.Server{Addr: ":" + config.MetricsPort, Handler: mux}
+		}
+		return n, nil
 	}
 
+	httpPool := server.NewHTTPPool()
+	mux := http.NewServeMux()
+	mux.Handle(consts.DefaultBasePath+"/", m.Instrument(httpPool))
+	mux.Handle(consts.DefaultMetricsPath, m)
+	srv := &http.Server{Handler: mux}
+	if config.Protocol == consts.ProtocolHTTPS {
+		srv.TLSConfig = tlsConfig
+	}
+	return &httpNode{srv: srv, lis: lis}, nil
 }
 
 func handleConfig(path string) *register.Config {
@@ -74,11 +138,106 @@ func handleConfig(path string) *register.Config {
 	if config.Protocol == "" {
 		config.Protocol = consts.ProtocolHTTP
 	}
+	switch config.Protocol {
+	case consts.ProtocolHTTP, consts.ProtocolGRPC:
+	case consts.ProtocolHTTPS:
+		if config.CertFile == "" || config.KeyFile == "" {
+			log.Println("https 协议 CertFile 与 KeyFile 不能为空")
+			os.Exit(0)
+		}
+	default:
+		log.Println("Protocol 不支持:", config.Protocol)
+		os.Exit(0)
+	}
+	if config.CAFile != "" && config.CertFile == "" {
+		log.Println("CAFile 需要同时配置 CertFile 与 KeyFile")
+		os.Exit(0)
+	}
 	if config.LeaseTtl == 0 {
 		config.LeaseTtl = 10
 	}
 	if config.DialTimeout == 0 {
 		config.DialTimeout = 5
 	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 10
+	}
+	if config.AppendFsync == "" {
+		config.AppendFsync = consts.FsyncDefault
+	}
+	switch config.AppendFsync {
+	case consts.FsyncAlways, consts.FsyncEverySec, consts.FsyncNever:
+	default:
+		log.Println("AppendFsync 不支持:", config.AppendFsync)
+		os.Exit(0)
+	}
+	if config.JanitorInterval == 0 {
+		config.JanitorInterval = int64(consts.DefaultJanitorInterval / time.Second)
+	}
+	if config.AutoCreateBytes == 0 {
+		config.AutoCreateBytes = consts.DefaultGroupCacheBytes
+	}
+	names := make(map[string]bool)
+	for i := range config.Groups {
+		group := &config.Groups[i]
+		if group.Name == "" {
+			log.Println("Groups.Name 不能为空")
+			os.Exit(0)
+		}
+		if names[group.Name] {
+			log.Println("Groups.Name 重复:", group.Name)
+			os.Exit(0)
+		}
+		names[group.Name] = true
+		if group.Policy == "" {
+			group.Policy = consts.PolicyDefault
+		}
+		if group.MaxTtl > 0 && group.DefaultTtl > group.MaxTtl {
+			log.Println("Groups.DefaultTtl 不能大于 MaxTtl:", group.Name)
+			os.Exit(0)
+		}
+		if group.Origin != "" && !strings.Contains(group.Origin, "{key}") {
+			log.Println("Groups.Origin 必须包含{key}:", group.Name)
+			os.Exit(0)
+		}
+	}
 	return &config
 }
+
+// applyGroups 按配置创建分组
+func applyGroups(config *register.Config) {
+	autoCreate := config.AutoCreate == nil || *config.AutoCreate
+	server.SetAutoCreate(autoCreate, config.AutoCreateBytes)
+	server.SetMemoryLimit(config.MaxMemory)
+	server.SetMaxGroups(config.MaxGroups)
+	server.SetJanitorInterval(time.Duration(config.JanitorInterval) * time.Second)
+	onShutdown(func() error {
+		server.StopJanitors()
+		return nil
+	})
+
+	for _, group := range config.Groups {
+		// DefaultTtl为0时与自动创建的分组一致,使用默认过期时长,小于0时永不过期
+		defaultTTL := consts.DefaultNodeCacheDuration
+		switch {
+		case group.DefaultTtl > 0:
+			defaultTTL = time.Duration(group.DefaultTtl) * time.Second
+		case group.DefaultTtl < 0:
+			defaultTTL = 0
+		}
+		ttl := server.WithTTL(defaultTTL, time.Duration(group.MaxTtl)*time.Second)
+		opts := []server.Option{ttl, server.WithWeight(group.Weight)}
+		if group.Origin != "" {
+			timeout := consts.DefaultOriginTimeout
+			if group.OriginTimeout > 0 {
+				timeout = time.Duration(group.OriginTimeout) * time.Second
+			}
+			opts = append(opts, server.WithGetter(server.NewHTTPGetter(group.Name, group.Origin, timeout)))
+		}
+		if _, err := server.NewGroupWithPolicy(group.Name, group.Policy, group.MaxBytes, opts...); err != nil {
+			log.Println(err)
+			os.Exit(0)
+		}
+		log.Printf("创建分组:%s,淘汰算法:%s,最大内存:%d", group.Name, group.Policy, group.MaxBytes)
+	}
+}
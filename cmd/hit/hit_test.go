@@ -17,11 +17,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/chenquan/hit/internal/consts"
+	"github.com/chenquan/hit/internal/grpc"
 	"github.com/chenquan/hit/internal/register"
 	"github.com/chenquan/hit/internal/server"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"reflect"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type Test interface {
@@ -47,3 +56,149 @@ func Test1(t *testing.T) {
 	_ = http.ListenAndServe(":"+config.Port, httpPool)
 
 }
+
+func TestHandleConfigGroups(t *testing.T) {
+	f, err := ioutil.TempFile("", "hit-*.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString(`
+Endpoints = ["localhost:2379"]
+NodeAddr = "localhost"
+NodeName = "node1"
+AutoCreate = false
+
+[[Groups]]
+Name = "users"
+MaxBytes = 1048576
+DefaultTtl = 60
+MaxTtl = 3600
+Policy = "tinylfu"
+
+[[Groups]]
+Name = "sessions"
+
+[[Groups]]
+Name = "forever"
+DefaultTtl = -1
+`)
+	_ = f.Close()
+
+	config := handleConfig(f.Name())
+	if config.AutoCreate == nil || *config.AutoCreate {
+		t.Fatalf("expected AutoCreate=false")
+	}
+	expect := []register.GroupConfig{
+		{Name: "users", MaxBytes: 1048576, DefaultTtl: 60, MaxTtl: 3600, Policy: "tinylfu"},
+		{Name: "sessions", Policy: consts.PolicyDefault},
+		{Name: "forever", DefaultTtl: -1, Policy: consts.PolicyDefault},
+	}
+	if !reflect.DeepEqual(config.Groups, expect) {
+		t.Fatalf("expected %+v but got %+v", expect, config.Groups)
+	}
+
+	defer server.SetAutoCreate(true, consts.DefaultGroupCacheBytes)
+	defer func(hooks []func() error) { shutdownHooks = hooks }(shutdownHooks)
+	applyGroups(config)
+	if server.GetGroup("users") == nil || server.GetGroup("sessions") == nil {
+		t.Fatalf("configured groups were not created")
+	}
+	// DefaultTtl为0时使用默认过期时长,小于0时永不过期
+	defaultExpire := time.Now().Add(consts.DefaultNodeCacheDuration).Unix()
+	if expire := server.GetGroup("sessions").ExpireAt(0); expire < defaultExpire-1 || expire > defaultExpire+1 {
+		t.Fatalf("expected default ttl for sessions but got expire %d", expire)
+	}
+	if expire := server.GetGroup("forever").ExpireAt(0); expire != 0 {
+		t.Fatalf("expected forever to never expire but got %d", expire)
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	})}
+
+	var steps []string
+	defer func(hooks []func() error) { shutdownHooks = hooks }(shutdownHooks)
+	shutdownHooks = nil
+	onShutdown(func() error {
+		steps = append(steps, "hook")
+		return nil
+	})
+
+	quit := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		run(&httpNode{srv: srv, lis: lis}, quit, func() error {
+			steps = append(steps, "deregister")
+			return nil
+		}, time.Second)
+		close(done)
+	}()
+
+	// 请求处理中收到退出信号,等待请求完成后再退出
+	result := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + lis.Addr().String())
+		if err == nil {
+			data, _ := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if string(data) != "ok" {
+				err = fmt.Errorf("unexpected body %q", data)
+			}
+		}
+		result <- err
+	}()
+	<-started
+	quit <- syscall.SIGTERM
+
+	if err := <-result; err != nil {
+		t.Fatalf("in-flight request failed: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("node did not stop")
+	}
+	if !reflect.DeepEqual(steps, []string{"deregister", "hook"}) {
+		t.Fatalf("unexpected shutdown steps %v", steps)
+	}
+	if _, err := net.DialTimeout("tcp", lis.Addr().String(), 100*time.Millisecond); err == nil {
+		t.Fatal("expected listener to be closed")
+	}
+}
+
+func TestGRPCNodeShutdown(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &grpcNode{srv: grpc.NewServer(), lis: lis}
+	go func() { _ = n.serve() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := n.shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewNodeListenFailed(t *testing.T) {
+	lis, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	// 端口已被占用时返回错误,由调用方撤销租约后退出
+	port := fmt.Sprint(lis.Addr().(*net.TCPAddr).Port)
+	if _, err := newNode(&register.Config{Protocol: consts.ProtocolHTTP, Port: port}, nil); err == nil {
+		t.Fatal("expected listen error")
+	}
+}
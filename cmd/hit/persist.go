@@ -0,0 +1,90 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/chenquan/hit/internal/register"
+	"github.com/chenquan/hit/internal/server"
+	"log"
+	"os"
+	"time"
+)
+
+// applySnapshot 从快照恢复缓存数据,并在停止节点时及每隔SnapshotInterval秒保存快照.
+// 需在创建分组之后、注册节点之前调用,未配置SnapshotPath时不做处理
+func applySnapshot(config *register.Config) {
+	if config.SnapshotPath == "" {
+		return
+	}
+	n, err := server.LoadSnapshot(config.SnapshotPath)
+	if err != nil {
+		log.Println("加载快照失败:", err)
+	} else {
+		log.Printf("加载快照:%s,恢复%d条数据", config.SnapshotPath, n)
+	}
+
+	save := func() error {
+		n, err := server.SaveSnapshot(config.SnapshotPath)
+		if err != nil {
+			return fmt.Errorf("保存快照失败: %v", err)
+		}
+		log.Printf("保存快照:%s,共%d条数据", config.SnapshotPath, n)
+		return nil
+	}
+	if config.SnapshotInterval <= 0 {
+		onShutdown(save)
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Duration(config.SnapshotInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := save(); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}()
+	// 等待定时保存结束后再保存最后一次快照,避免同时写入同一个文件
+	onShutdown(func() error {
+		close(stop)
+		<-done
+		return save()
+	})
+}
+
+// applyAppendLog 回放追加日志恢复缓存数据,之后分组的写入与删除追加到日志中,停止节点时关闭日志.
+// 需在创建分组之后、注册节点之前调用,未配置AppendLogDir时不做处理
+func applyAppendLog(config *register.Config) {
+	if config.AppendLogDir == "" {
+		return
+	}
+	l, n, err := server.OpenAppendLog(config.AppendLogDir, config.AppendFsync, config.AppendCompactBytes)
+	if err != nil {
+		log.Println("打开追加日志失败:", err)
+		os.Exit(0)
+	}
+	log.Printf("回放追加日志:%s,共%d条记录", config.AppendLogDir, n)
+	onShutdown(l.Close)
+}
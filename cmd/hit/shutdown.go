@@ -0,0 +1,136 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	ggrpc "google.golang.org/grpc"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// node 节点服务
+type node interface {
+	serve() error
+	shutdown(ctx context.Context) error
+}
+
+// httpNode HTTP/HTTPS节点
+type httpNode struct {
+	srv *http.Server
+	lis net.Listener
+}
+
+func (n *httpNode) serve() error {
+	var err error
+	if n.srv.TLSConfig != nil {
+		err = n.srv.ServeTLS(n.lis, "", "")
+	} else {
+		err = n.srv.Serve(n.lis)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (n *httpNode) shutdown(ctx context.Context) error {
+	return n.srv.Shutdown(ctx)
+}
+
+// grpcNode gRPC节点,metrics为监控指标服务,可为nil
+type grpcNode struct {
+	srv     *ggrpc.Server
+	lis     net.Listener
+	metrics *http.Server
+}
+
+func (n *grpcNode) serve() error {
+	if n.metrics != nil {
+		go func() {
+			if err := n.metrics.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println(err)
+			}
+		}()
+	}
+	return n.srv.Serve(n.lis)
+}
+
+func (n *grpcNode) shutdown(ctx context.Context) error {
+	if n.metrics != nil {
+		_ = n.metrics.Shutdown(ctx)
+	}
+	done := make(chan struct{})
+	go func() {
+		n.srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// 超时后强制关闭未完成的请求
+		n.srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// shutdownHooks 节点停止服务后、进程退出前依次执行,例如保存缓存快照
+var shutdownHooks []func() error
+
+// onShutdown 注册退出前执行的函数
+func onShutdown(hook func() error) {
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// run 运行节点直到收到退出信号或服务出错,然后优雅停止:
+// 先从etcd注销节点使客户端不再路由到该节点,再在timeout内等待处理中的请求完成,最后执行shutdownHooks
+func run(n node, quit <-chan os.Signal, deregister func() error, timeout time.Duration) {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- n.serve()
+	}()
+
+	select {
+	case sig := <-quit:
+		log.Println("收到信号:", sig, "开始停止节点")
+	case err := <-errChan:
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	if err := deregister(); err != nil {
+		log.Println("注销节点失败:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := n.shutdown(ctx); err != nil {
+		log.Println("等待请求完成超时:", err)
+	}
+
+	for _, hook := range shutdownHooks {
+		if err := hook(); err != nil {
+			log.Println(err)
+		}
+	}
+	log.Println("节点已停止")
+}
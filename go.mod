@@ -14,6 +14,7 @@ require (
 	github.com/golang/protobuf v1.4.2
 	github.com/google/uuid v1.1.1 // indirect
 	go.uber.org/zap v1.15.0 // indirect
+	google.golang.org/grpc v1.27.0
 	google.golang.org/protobuf v1.25.0
 )
 
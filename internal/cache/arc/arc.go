@@ -0,0 +1,323 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// ARC(自适应替换缓存): T1保存只访问过一次的记录,T2保存访问过多次的记录,
+// B1/B2为对应的幽灵列表,只保存key与大小.幽灵命中时调整T1的目标大小p,
+// 使缓存在偏重最近访问与偏重访问频率之间自动切换.
+
+package arc
+
+import (
+	"container/list"
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/expiry"
+)
+
+// 记录所在的列表
+const (
+	listT1 = iota
+	listT2
+	listB1
+	listB2
+)
+
+type entry struct {
+	key   string
+	value cache.Valuer // 幽灵记录为nil
+	size  int64
+	list  int
+	item  expiry.Item // 到期索引,只包含T1与T2中的记录
+}
+
+type Cache struct {
+	maxBytes    int64                                // 最大内存
+	p           int64                                // T1的目标内存
+	lists       [4]*list.List                        // T1,T2,B1,B2
+	bytes       [4]int64                             // 各列表的内存
+	cache       map[string]*list.Element             // 缓存字典,包含幽灵记录
+	expiry      expiry.Heap                          // 到期索引
+	evictions   int64                                // 因内存不足淘汰的条数
+	expirations int64                                // 因过期删除的条数
+	OnEvicted   func(key string, value cache.Valuer) // (可选)移除缓存中某条记录时执行
+}
+
+// NewARCCache 创建ARCCache
+func NewARCCache(maxBytes int64, onEvicted func(string, cache.Valuer)) *Cache {
+	c := &Cache{
+		maxBytes:  maxBytes,
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+	c.init()
+	return c
+}
+
+func (c *Cache) init() {
+	for i := range c.lists {
+		c.lists[i] = list.New()
+		c.bytes[i] = 0
+	}
+	c.cache = make(map[string]*list.Element)
+	c.expiry.Clear()
+	c.p = 0
+}
+
+// Len 缓存列表的条数
+func (c *Cache) Len() int {
+	if c.cache == nil {
+		return 0
+	}
+	return c.lists[listT1].Len() + c.lists[listT2].Len()
+}
+
+// Add 添加一个值到缓存中
+func (c *Cache) Add(key string, value cache.Valuer) {
+	if c.cache == nil {
+		c.init()
+	}
+	size := int64(len(key)) + int64(value.Len())
+	inB2 := false
+
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		switch kv.list {
+		case listB1:
+			// 最近访问的记录被过早淘汰,增大T1
+			c.p = minInt64(c.maxBytes, c.p+maxInt64(size, size*c.bytes[listB2]/maxInt64(c.bytes[listB1], 1)))
+		case listB2:
+			// 频繁访问的记录被过早淘汰,减小T1
+			c.p = maxInt64(0, c.p-maxInt64(size, size*c.bytes[listB1]/maxInt64(c.bytes[listB2], 1)))
+			inB2 = true
+		}
+		c.unlink(ele)
+		kv.value = value
+		kv.size = size
+		c.push(kv, listT2)
+		c.expiry.Update(&kv.item, value.Expire())
+	} else {
+		kv := &entry{key: key, value: value, size: size, item: expiry.Item{Key: key}}
+		c.push(kv, listT1)
+		c.expiry.Update(&kv.item, value.Expire())
+	}
+
+	for c.maxBytes != 0 && c.bytes[listT1]+c.bytes[listT2] > c.maxBytes {
+		// 优先删除已过期的记录
+		if !c.removeExpired(expiry.Now()) {
+			c.replace(inB2)
+		}
+	}
+	c.trimGhosts()
+}
+
+// Get 查找键的值
+func (c *Cache) Get(key string) (value cache.Valuer, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.list == listB1 || kv.list == listB2 {
+			return nil, false
+		}
+		// 再次访问,移入T2
+		c.unlink(ele)
+		c.push(kv, listT2)
+		return kv.value, true
+	}
+	return
+}
+
+// Peek 查找键的值,不更新访问记录
+func (c *Cache) Peek(key string) (value cache.Valuer, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, ok := c.cache[key]; ok {
+		if kv := ele.Value.(*entry); kv.value != nil {
+			return kv.value, true
+		}
+	}
+	return
+}
+
+// replace 根据目标大小p从T1或T2淘汰最久未访问的记录到对应的幽灵列表
+func (c *Cache) replace(inB2 bool) {
+	t1 := c.bytes[listT1]
+	if t1 > 0 && (t1 > c.p || (inB2 && t1 == c.p) || c.lists[listT2].Len() == 0) {
+		c.demote(c.lists[listT1].Back(), listB1)
+	} else {
+		c.demote(c.lists[listT2].Back(), listB2)
+	}
+}
+
+// Evict 按目标大小p淘汰一条记录,优先淘汰已过期的记录
+func (c *Cache) Evict() bool {
+	if c.Len() == 0 {
+		return false
+	}
+	if !c.removeExpired(expiry.Now()) {
+		c.replace(false)
+	}
+	c.trimGhosts()
+	return true
+}
+
+// RemoveExpired 删除到期时间不晚于timestamp的记录,返回删除条数.过期的记录不保留幽灵记录
+func (c *Cache) RemoveExpired(timestamp int64) int {
+	if c.cache == nil {
+		return 0
+	}
+	n := 0
+	for c.removeExpired(timestamp) {
+		n++
+	}
+	return n
+}
+
+// removeExpired 删除一条到期时间不晚于timestamp的记录,没有时返回false
+func (c *Cache) removeExpired(timestamp int64) bool {
+	item := c.expiry.PeekExpired(timestamp)
+	if item == nil {
+		return false
+	}
+	c.Remove(item.Key)
+	c.expirations++
+	return true
+}
+
+// demote 淘汰记录的值,只保留幽灵记录
+func (c *Cache) demote(ele *list.Element, ghost int) {
+	if ele == nil {
+		return
+	}
+	kv := ele.Value.(*entry)
+	c.unlink(ele)
+	c.expiry.Remove(&kv.item)
+	value := kv.value
+	kv.value = nil
+	c.push(kv, ghost)
+	c.evictions++
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, value)
+	}
+}
+
+// trimGhosts 限制幽灵列表大小: |T1|+|B1| <= c, |T1|+|T2|+|B1|+|B2| <= 2c
+func (c *Cache) trimGhosts() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.lists[listB1].Len() > 0 && c.bytes[listT1]+c.bytes[listB1] > c.maxBytes {
+		c.drop(c.lists[listB1].Back())
+	}
+	for c.lists[listB2].Len() > 0 &&
+		c.bytes[listT1]+c.bytes[listT2]+c.bytes[listB1]+c.bytes[listB2] > 2*c.maxBytes {
+		c.drop(c.lists[listB2].Back())
+	}
+}
+
+// push 将记录放入指定列表的队首
+func (c *Cache) push(kv *entry, to int) {
+	kv.list = to
+	c.cache[kv.key] = c.lists[to].PushFront(kv)
+	c.bytes[to] += kv.size
+}
+
+// unlink 将记录从所在列表中移出
+func (c *Cache) unlink(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	c.lists[kv.list].Remove(ele)
+	c.bytes[kv.list] -= kv.size
+}
+
+// drop 彻底删除记录
+func (c *Cache) drop(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	c.unlink(ele)
+	c.expiry.Remove(&kv.item)
+	delete(c.cache, kv.key)
+}
+
+// Remove 移除指定key的数据
+func (c *Cache) Remove(key string) {
+	if c.cache == nil {
+		return
+	}
+	if ele, hit := c.cache[key]; hit {
+		kv := ele.Value.(*entry)
+		c.drop(ele)
+		if kv.value != nil && c.OnEvicted != nil {
+			c.OnEvicted(kv.key, kv.value)
+		}
+	}
+}
+
+// Clear 清空全部数据
+func (c *Cache) Clear() {
+	if c.cache == nil {
+		return
+	}
+	if c.OnEvicted != nil {
+		for _, e := range c.cache {
+			kv := e.Value.(*entry)
+			if kv.value != nil {
+				c.OnEvicted(kv.key, kv.value)
+			}
+		}
+	}
+	c.init()
+}
+
+// Range 依次遍历T1与T2中的记录,不包含幽灵记录
+func (c *Cache) Range(fn func(key string, value cache.Valuer) bool) {
+	if c.cache == nil {
+		return
+	}
+	for _, l := range []int{listT1, listT2} {
+		for e := c.lists[l].Back(); e != nil; e = e.Prev() {
+			kv := e.Value.(*entry)
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+	}
+}
+
+// Stats 淘汰与内存统计
+func (c *Cache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.bytes[listT1] + c.bytes[listT2],
+		Items:       int64(c.Len()),
+	}
+}
+
+// minInt64 返回较小值
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// maxInt64 返回较大值
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
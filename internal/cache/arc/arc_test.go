@@ -0,0 +1,141 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package arc
+
+import (
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type String string
+
+func (s String) Bytes() []byte {
+	return []byte(s)
+}
+
+func (s String) Len() int {
+	return len(s)
+}
+func (s String) Expire() int64 {
+	return 0
+}
+func (s String) SetExpire(timestamp int64) {
+}
+func (s String) GroupName() string {
+	return ""
+}
+func (s String) String() string {
+	return string(s)
+}
+
+func TestGet(t *testing.T) {
+	arc := NewARCCache(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	if v, ok := arc.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("arc hit key1=1234 failed")
+	}
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("arc miss key2 failed")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	arc := NewARCCache(int64(0), nil)
+	arc.Add("key", String("1"))
+	arc.Add("key", String("111"))
+
+	if arc.bytes[listT1]+arc.bytes[listT2] != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", arc.bytes[listT1]+arc.bytes[listT2])
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value cache.Valuer) {
+		keys = append(keys, key)
+	}
+	arc := NewARCCache(int64(10), callback)
+	arc.Add("key1", String("123456"))
+	arc.Add("k2", String("k2"))
+	arc.Add("k3", String("k3"))
+	arc.Add("k4", String("k4"))
+
+	expect := []string{"key1", "k2"}
+
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+	if arc.Len() != 2 {
+		t.Fatalf("expected 2 but got %d", arc.Len())
+	}
+}
+
+func TestFrequentKeysSurviveScan(t *testing.T) {
+	arc := NewARCCache(int64(100), nil)
+	// 8 bytes per entry
+	for i := 0; i < 5; i++ {
+		key := "hot" + strconv.Itoa(i)
+		arc.Add(key, String("vvvv"))
+		arc.Get(key)
+	}
+	for i := 0; i < 100; i++ {
+		arc.Add("s"+strconv.Itoa(i+100), String("vvvv"))
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := arc.Get("hot" + strconv.Itoa(i)); !ok {
+			t.Fatalf("frequent key hot%d was evicted by scan", i)
+		}
+	}
+}
+
+func TestGhostHitAdapts(t *testing.T) {
+	arc := NewARCCache(int64(16), nil)
+	arc.Add("k1", String("vvvvvv"))
+	arc.Add("k2", String("vvvvvv"))
+	arc.Get("k2")
+	// k1 进入幽灵列表B1
+	arc.Add("k3", String("vvvvvv"))
+	if _, ok := arc.Get("k1"); ok {
+		t.Fatalf("k1 should be evicted")
+	}
+	if arc.lists[listB1].Len() != 1 {
+		t.Fatalf("expected 1 ghost in B1 but got %d", arc.lists[listB1].Len())
+	}
+	// 幽灵命中,p增大且k1进入T2
+	arc.Add("k1", String("vvvvvv"))
+	if arc.p == 0 {
+		t.Fatalf("expected p to grow after B1 ghost hit")
+	}
+	if ele, ok := arc.cache["k1"]; !ok || ele.Value.(*entry).list != listT2 {
+		t.Fatalf("k1 should be in T2 after ghost hit")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	arc := NewARCCache(int64(16), nil)
+	arc.Add("k1", String("vvvvvv"))
+	arc.Add("k2", String("vvvvvv"))
+	arc.Add("k3", String("vvvvvv"))
+	arc.Remove("k1")
+	arc.Remove("k2")
+	arc.Remove("k3")
+	if arc.Len() != 0 || len(arc.cache) != 0 {
+		t.Fatalf("Remove failed")
+	}
+}
@@ -26,6 +26,62 @@ type Cache interface {
 	Len() int
 }
 
+// Peeker 查找键的值,但不更新访问顺序与频率,可在读锁下并发调用
+type Peeker interface {
+	Peek(key string) (valuer Valuer, ok bool)
+}
+
+// Expirer 支持主动删除过期数据的缓存
+type Expirer interface {
+	// RemoveExpired 删除到期时间不晚于timestamp的数据,返回删除条数
+	RemoveExpired(timestamp int64) int
+}
+
+// Evicter 可以按淘汰算法主动淘汰数据的缓存
+type Evicter interface {
+	// Evict 淘汰一条记录,缓存为空时返回false
+	Evict() bool
+}
+
+// Ranger 可以遍历所有数据的缓存
+type Ranger interface {
+	// Range 按淘汰顺序(大致)遍历数据,先淘汰的先遍历,fn返回false时停止.
+	// 按遍历顺序重新写入可以近似恢复访问顺序.fn中不能修改缓存
+	Range(fn func(key string, valuer Valuer) bool)
+}
+
+// Stats 缓存统计
+type Stats struct {
+	Gets        int64 `json:"gets"`        // 查询次数
+	Hits        int64 `json:"hits"`        // 命中次数
+	Misses      int64 `json:"misses"`      // 未命中次数
+	Sets        int64 `json:"sets"`        // 写入次数
+	Deletes     int64 `json:"deletes"`     // 删除次数
+	Evictions   int64 `json:"evictions"`   // 因内存不足淘汰的条数
+	Expirations int64 `json:"expirations"` // 因过期删除的条数
+	Bytes       int64 `json:"bytes"`       // 当前占用内存
+	Items       int64 `json:"items"`       // 当前条数
+}
+
+// Add 累加统计
+func (s Stats) Add(o Stats) Stats {
+	s.Gets += o.Gets
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Sets += o.Sets
+	s.Deletes += o.Deletes
+	s.Evictions += o.Evictions
+	s.Expirations += o.Expirations
+	s.Bytes += o.Bytes
+	s.Items += o.Items
+	return s
+}
+
+// Stater 提供统计信息的缓存
+type Stater interface {
+	Stats() Stats
+}
+
 //使用Len值计算需要多少字节
 type Valuer interface {
 	fmt.Stringer
@@ -0,0 +1,116 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// 到期索引: 各淘汰算法按到期时间索引设置了到期时间的记录,
+// 用于后台主动删除过期数据,以及内存不足时优先淘汰已过期的数据.
+
+package expiry
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Now 当前时间戳,测试时可替换
+var Now = func() int64 {
+	return time.Now().Unix()
+}
+
+// Item 到期堆中的记录,嵌入各缓存的记录中
+type Item struct {
+	Key    string
+	expire int64
+	index  int // 在堆中的下标+1,0表示不在堆中
+}
+
+// Heap 按到期时间排序的最小堆,只包含设置了到期时间的记录.零值即可使用
+type Heap struct {
+	items items
+}
+
+// Len 堆中的记录数
+func (h *Heap) Len() int {
+	return len(h.items)
+}
+
+// Update 更新记录的到期时间,到期时间小于等于0表示永不过期,从堆中移除
+func (h *Heap) Update(item *Item, expire int64) {
+	item.expire = expire
+	switch {
+	case expire <= 0:
+		h.Remove(item)
+	case item.index > 0:
+		heap.Fix(&h.items, item.index-1)
+	default:
+		heap.Push(&h.items, item)
+	}
+}
+
+// Remove 将记录从堆中移除
+func (h *Heap) Remove(item *Item) {
+	if item.index > 0 {
+		heap.Remove(&h.items, item.index-1)
+	}
+}
+
+// PeekExpired 返回最早到期且到期时间不晚于timestamp的记录,没有时返回nil
+func (h *Heap) PeekExpired(timestamp int64) *Item {
+	if len(h.items) > 0 && h.items[0].expire <= timestamp {
+		return h.items[0]
+	}
+	return nil
+}
+
+// Clear 清空堆
+func (h *Heap) Clear() {
+	for _, item := range h.items {
+		item.index = 0
+	}
+	h.items = nil
+}
+
+// items 实现heap.Interface
+type items []*Item
+
+func (h items) Len() int {
+	return len(h)
+}
+
+func (h items) Less(i, j int) bool {
+	return h[i].expire < h[j].expire
+}
+
+func (h items) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i + 1
+	h[j].index = j + 1
+}
+
+func (h *items) Push(x interface{}) {
+	item := x.(*Item)
+	item.index = len(*h) + 1
+	*h = append(*h, item)
+}
+
+func (h *items) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = 0
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,73 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Janitor 支持后台定期清理过期数据的缓存
+type Janitor interface {
+	// StartJanitor 启动后台清理,重复调用时按新的间隔重启
+	StartJanitor(interval time.Duration)
+	// StopJanitor 停止后台清理
+	StopJanitor()
+}
+
+// KeyExpirer 可以按key删除过期数据的缓存
+type KeyExpirer interface {
+	// Expire 删除已过期的key,计入过期统计
+	Expire(key string)
+}
+
+// janitor 定期执行清理函数
+type janitor struct {
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+func (j *janitor) start(interval time.Duration, sweep func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil {
+		close(j.stop)
+	}
+	stop := make(chan struct{})
+	j.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (j *janitor) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil {
+		close(j.stop)
+		j.stop = nil
+	}
+}
@@ -0,0 +1,243 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package lfu
+
+import (
+	"container/list"
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/expiry"
+)
+
+type entry struct {
+	key    string
+	value  cache.Valuer
+	bucket *list.Element // 所在频率桶
+	item   expiry.Item   // 到期索引
+}
+
+// bucket 访问频率相同的记录,队首为最近访问
+type bucket struct {
+	freq  int64
+	items *list.List
+}
+
+type Cache struct {
+	maxBytes     int64                                // 最大内存
+	currentBytes int64                                // 当前内存
+	buckets      *list.List                           // 频率桶队列,按频率升序
+	cache        map[string]*list.Element             // 缓存字典
+	expiry       expiry.Heap                          // 到期索引
+	evictions    int64                                // 因内存不足淘汰的条数
+	expirations  int64                                // 因过期删除的条数
+	OnEvicted    func(key string, value cache.Valuer) // (可选)移除缓存中某条记录时执行
+}
+
+// NewLFUCache 创建LFUCache
+func NewLFUCache(maxBytes int64, onEvicted func(string, cache.Valuer)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		buckets:   list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Len 缓存列表的条数
+func (c *Cache) Len() int {
+	if c.cache == nil {
+		return 0
+	}
+	return len(c.cache)
+}
+
+// Add 添加一个值到缓存中
+func (c *Cache) Add(key string, value cache.Valuer) {
+	if c.cache == nil {
+		c.cache = make(map[string]*list.Element)
+		c.buckets = list.New()
+	}
+
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		c.currentBytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		c.expiry.Update(&kv.item, value.Expire())
+		c.increment(ele)
+	} else {
+		// 新记录进入频率为1的桶
+		front := c.buckets.Front()
+		if front == nil || front.Value.(*bucket).freq != 1 {
+			front = c.buckets.PushFront(&bucket{freq: 1, items: list.New()})
+		}
+		kv := &entry{key: key, value: value, bucket: front, item: expiry.Item{Key: key}}
+		c.cache[key] = front.Value.(*bucket).items.PushFront(kv)
+		c.currentBytes += int64(len(key)) + int64(value.Len())
+		c.expiry.Update(&kv.item, value.Expire())
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.currentBytes {
+		c.removeLeast()
+	}
+}
+
+// Get 查找键的值
+func (c *Cache) Get(key string) (value cache.Valuer, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, ok := c.cache[key]; ok {
+		c.increment(ele)
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
+// Peek 查找键的值,不更新访问记录
+func (c *Cache) Peek(key string) (value cache.Valuer, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, ok := c.cache[key]; ok {
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
+// increment 将记录移入下一个频率桶
+func (c *Cache) increment(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	cur := kv.bucket
+	b := cur.Value.(*bucket)
+
+	next := cur.Next()
+	if next == nil || next.Value.(*bucket).freq != b.freq+1 {
+		next = c.buckets.InsertAfter(&bucket{freq: b.freq + 1, items: list.New()}, cur)
+	}
+	b.items.Remove(ele)
+	kv.bucket = next
+	c.cache[kv.key] = next.Value.(*bucket).items.PushFront(kv)
+
+	if b.items.Len() == 0 {
+		c.buckets.Remove(cur)
+	}
+}
+
+// removeLeast 删除访问频率最低的记录,频率相同时删除最久未访问的记录,优先删除已过期的记录
+func (c *Cache) removeLeast() {
+	if c.cache == nil {
+		return
+	}
+	if item := c.expiry.PeekExpired(expiry.Now()); item != nil {
+		c.removeElement(c.cache[item.Key])
+		c.expirations++
+		return
+	}
+	front := c.buckets.Front()
+	if front != nil {
+		c.removeElement(front.Value.(*bucket).items.Back())
+		c.evictions++
+	}
+}
+
+// Evict 淘汰一条访问频率最低的记录,优先淘汰已过期的记录
+func (c *Cache) Evict() bool {
+	if c.Len() == 0 {
+		return false
+	}
+	c.removeLeast()
+	return true
+}
+
+// RemoveExpired 删除到期时间不晚于timestamp的记录,返回删除条数
+func (c *Cache) RemoveExpired(timestamp int64) int {
+	if c.cache == nil {
+		return 0
+	}
+	n := 0
+	for item := c.expiry.PeekExpired(timestamp); item != nil; item = c.expiry.PeekExpired(timestamp) {
+		c.removeElement(c.cache[item.Key])
+		n++
+	}
+	c.expirations += int64(n)
+	return n
+}
+
+func (c *Cache) removeElement(e *list.Element) {
+	kv := e.Value.(*entry)
+	b := kv.bucket.Value.(*bucket)
+	b.items.Remove(e)
+	if b.items.Len() == 0 {
+		c.buckets.Remove(kv.bucket)
+	}
+	c.expiry.Remove(&kv.item)
+	delete(c.cache, kv.key)
+	c.currentBytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// Remove 移除指定key的数据
+func (c *Cache) Remove(key string) {
+	if c.cache == nil {
+		return
+	}
+	if ele, hit := c.cache[key]; hit {
+		c.removeElement(ele)
+	}
+}
+
+// Clear 清空全部数据
+func (c *Cache) Clear() {
+	if c.cache == nil {
+		return
+	}
+	if c.OnEvicted != nil {
+		for _, e := range c.cache {
+			kv := e.Value.(*entry)
+			c.OnEvicted(kv.key, kv.value)
+		}
+	}
+	c.buckets = nil
+	c.cache = nil
+	c.expiry.Clear()
+	c.currentBytes = 0
+}
+
+// Range 从访问频率最低的记录开始遍历
+func (c *Cache) Range(fn func(key string, value cache.Valuer) bool) {
+	if c.cache == nil {
+		return
+	}
+	for b := c.buckets.Front(); b != nil; b = b.Next() {
+		for e := b.Value.(*bucket).items.Back(); e != nil; e = e.Prev() {
+			kv := e.Value.(*entry)
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+	}
+}
+
+// Stats 淘汰与内存统计
+func (c *Cache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.currentBytes,
+		Items:       int64(c.Len()),
+	}
+}
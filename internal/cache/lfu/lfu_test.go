@@ -0,0 +1,118 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package lfu
+
+import (
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"reflect"
+	"testing"
+)
+
+type String string
+
+func (s String) Bytes() []byte {
+	return []byte(s)
+}
+
+func (s String) Len() int {
+	return len(s)
+}
+func (s String) Expire() int64 {
+	return 0
+}
+func (s String) SetExpire(timestamp int64) {
+}
+func (s String) GroupName() string {
+	return ""
+}
+func (s String) String() string {
+	return string(s)
+}
+
+func TestGet(t *testing.T) {
+	lfu := NewLFUCache(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("lfu hit key1=1234 failed")
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("lfu miss key2 failed")
+	}
+}
+
+func TestRemoveLeast(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	capSize := len(k1 + k2 + v1 + v2)
+	lfu := NewLFUCache(int64(capSize), nil)
+	lfu.Add(k1, String(v1))
+	lfu.Add(k2, String(v2))
+	// key1 访问频率高于 key2
+	lfu.Get(k1)
+	lfu.Add(k3, String(v3))
+
+	if _, ok := lfu.Get(k2); ok || lfu.Len() != 2 {
+		t.Fatalf("RemoveLeast key2 failed")
+	}
+	if _, ok := lfu.Get(k1); !ok {
+		t.Fatalf("frequent key1 should not be removed")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value cache.Valuer) {
+		keys = append(keys, key)
+	}
+	lfu := NewLFUCache(int64(10), callback)
+	lfu.Add("key1", String("123456"))
+	lfu.Add("k2", String("k2"))
+	lfu.Get("k2")
+	lfu.Add("k3", String("k3"))
+	lfu.Add("k4", String("k4"))
+
+	expect := []string{"key1", "k3"}
+
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	lfu := NewLFUCache(int64(0), nil)
+	lfu.Add("key", String("1"))
+	lfu.Add("key", String("111"))
+
+	if lfu.currentBytes != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", lfu.currentBytes)
+	}
+	if lfu.Len() != 1 {
+		t.Fatal("expected 1 but got", lfu.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	lfu := NewLFUCache(int64(0), nil)
+	lfu.Add("key1", String("1"))
+	lfu.Add("key2", String("2"))
+	lfu.Get("key1")
+	lfu.Remove("key1")
+
+	if _, ok := lfu.Get("key1"); ok || lfu.Len() != 1 || lfu.buckets.Len() != 1 {
+		t.Fatalf("Remove key1 failed")
+	}
+}
@@ -20,17 +20,22 @@ import (
 	"container/list"
 	"fmt"
 	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/expiry"
 )
 
 type entry struct {
 	key   string
 	value cache.Valuer
+	item  expiry.Item // 到期索引
 }
 type Cache struct {
 	maxBytes     int64                                // 最大内存
 	currentBytes int64                                // 当前内存
 	ll           *list.List                           // 缓存队列
 	cache        map[string]*list.Element             // 缓存字典
+	expiry       expiry.Heap                          // 到期索引
+	evictions    int64                                // 因内存不足淘汰的条数
+	expirations  int64                                // 因过期删除的条数
 	OnEvicted    func(key string, value cache.Valuer) // (可选)移除缓存中某条记录时执行
 }
 
@@ -64,10 +69,13 @@ func (c *Cache) Add(key string, value cache.Valuer) {
 		kv := ele.Value.(*entry)
 		c.currentBytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
+		c.expiry.Update(&kv.item, value.Expire())
 	} else {
-		ele := c.ll.PushFront(&entry{key, value})
+		kv := &entry{key: key, value: value, item: expiry.Item{Key: key}}
+		ele := c.ll.PushFront(kv)
 		c.cache[key] = ele
 		c.currentBytes += int64(len(key)) + int64(value.Len())
+		c.expiry.Update(&kv.item, value.Expire())
 	}
 	for c.maxBytes != 0 && c.maxBytes < c.currentBytes {
 		c.removeOldest()
@@ -87,21 +95,55 @@ func (c *Cache) Get(key string) (value cache.Valuer, ok bool) {
 	return
 }
 
-// RemoveOldest 删除旧的记录
+// Peek 查找键的值,不更新访问记录
+func (c *Cache) Peek(key string) (value cache.Valuer, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, ok := c.cache[key]; ok {
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
+// RemoveOldest 删除旧的记录,优先删除已过期的记录
 func (c *Cache) removeOldest() {
 	if c.cache == nil {
 		return
 	}
+	if item := c.expiry.PeekExpired(expiry.Now()); item != nil {
+		c.removeElement(c.cache[item.Key])
+		c.expirations++
+		return
+	}
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.currentBytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
-		}
+		c.removeElement(ele)
+		c.evictions++
+	}
+}
+
+// Evict 淘汰一条记录,优先淘汰已过期的记录
+func (c *Cache) Evict() bool {
+	if c.Len() == 0 {
+		return false
 	}
+	c.removeOldest()
+	return true
+}
+
+// RemoveExpired 删除到期时间不晚于timestamp的记录,返回删除条数
+func (c *Cache) RemoveExpired(timestamp int64) int {
+	if c.cache == nil {
+		return 0
+	}
+	n := 0
+	for item := c.expiry.PeekExpired(timestamp); item != nil; item = c.expiry.PeekExpired(timestamp) {
+		c.removeElement(c.cache[item.Key])
+		n++
+	}
+	c.expirations += int64(n)
+	return n
 }
 
 //
@@ -111,6 +153,7 @@ func (c *Cache) removeElement(e *list.Element) {
 	}
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
+	c.expiry.Remove(&kv.item)
 	delete(c.cache, kv.key)
 	c.currentBytes -= int64(len(kv.key)) + int64(kv.value.Len())
 	if c.OnEvicted != nil {
@@ -141,6 +184,31 @@ func (c *Cache) Clear() {
 	}
 	c.ll = nil
 	c.cache = nil
+	c.expiry.Clear()
+	c.currentBytes = 0
+}
+
+// Range 从最久未使用的记录开始遍历
+func (c *Cache) Range(fn func(key string, value cache.Valuer) bool) {
+	if c.cache == nil {
+		return
+	}
+	for e := c.ll.Back(); e != nil; e = e.Prev() {
+		kv := e.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// Stats 淘汰与内存统计
+func (c *Cache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.currentBytes,
+		Items:       int64(c.Len()),
+	}
 }
 
 type Value struct {
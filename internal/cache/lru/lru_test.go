@@ -18,6 +18,7 @@ package lru
 
 import (
 	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/expiry"
 	"reflect"
 	"testing"
 )
@@ -31,6 +32,17 @@ func (s String) Bytes() []byte {
 func (s String) Len() int {
 	return len(s)
 }
+func (s String) Expire() int64 {
+	return 0
+}
+func (s String) SetExpire(timestamp int64) {
+}
+func (s String) GroupName() string {
+	return ""
+}
+func (s String) String() string {
+	return string(s)
+}
 
 func TestGet(t *testing.T) {
 	lru := NewLRUCache(int64(0), nil)
@@ -84,3 +96,62 @@ func TestAdd(t *testing.T) {
 		t.Fatal("expected 6 but got", lru.currentBytes)
 	}
 }
+
+func TestRemoveExpired(t *testing.T) {
+	lru := NewLRUCache(int64(0), nil)
+	lru.Add("key1", NewValue([]byte("v1"), 100, "group"))
+	lru.Add("key2", NewValue([]byte("v2"), 200, "group"))
+	lru.Add("key3", NewValue([]byte("v3"), 0, "group"))
+
+	if n := lru.RemoveExpired(150); n != 1 {
+		t.Fatalf("expected 1 expired but got %d", n)
+	}
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("expired key1 should be removed")
+	}
+	// 永不过期的记录不会被删除
+	if n := lru.RemoveExpired(1 << 62); n != 1 || lru.Len() != 1 {
+		t.Fatalf("RemoveExpired key2 failed")
+	}
+	if lru.currentBytes != int64(len("key3")+len("v3")) {
+		t.Fatal("unexpected bytes", lru.currentBytes)
+	}
+}
+
+func TestRemoveOldestPrefersExpired(t *testing.T) {
+	defer func(f func() int64) { expiry.Now = f }(expiry.Now)
+	expiry.Now = func() int64 { return 150 }
+
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	capSize := len(k1 + k2 + v1 + v2)
+	lru := NewLRUCache(int64(capSize), nil)
+	lru.Add(k1, NewValue([]byte(v1), 200, "group"))
+	lru.Add(k2, NewValue([]byte(v2), 100, "group"))
+	lru.Add(k3, NewValue([]byte(v3), 200, "group"))
+
+	if _, ok := lru.Get(k1); !ok {
+		t.Fatalf("live key1 should not be evicted")
+	}
+	if _, ok := lru.Get(k2); ok || lru.Len() != 2 {
+		t.Fatalf("expired key2 should be evicted first")
+	}
+}
+
+func TestRange(t *testing.T) {
+	lru := NewLRUCache(int64(0), nil)
+	lru.Add("key1", String("1"))
+	lru.Add("key2", String("2"))
+	lru.Add("key3", String("3"))
+	lru.Get("key1")
+
+	var keys []string
+	lru.Range(func(key string, value cache.Valuer) bool {
+		keys = append(keys, key)
+		return true
+	})
+	// 从最久未使用的记录开始遍历
+	if expect := []string{"key2", "key3", "key1"}; !reflect.DeepEqual(keys, expect) {
+		t.Fatalf("expected %v but got %v", expect, keys)
+	}
+}
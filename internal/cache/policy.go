@@ -0,0 +1,51 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cache
+
+import (
+	"fmt"
+	"github.com/chenquan/hit/internal/cache/arc"
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lfu"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/cache/tinylfu"
+	"github.com/chenquan/hit/internal/consts"
+)
+
+// NewCache 根据淘汰算法创建缓存(非并发安全),policy为空时使用LRU
+func NewCache(policy string, maxBytes int64) (cache.Cache, error) {
+	switch policy {
+	case consts.PolicyLRU, "":
+		return lru.NewLRUCache(maxBytes, nil), nil
+	case consts.PolicyLFU:
+		return lfu.NewLFUCache(maxBytes, nil), nil
+	case consts.PolicyARC:
+		return arc.NewARCCache(maxBytes, nil), nil
+	case consts.PolicyTinyLFU:
+		return tinylfu.NewTinyLFUCache(maxBytes, nil), nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy: %s", policy)
+}
+
+// NewSyncCacheWithPolicy 根据淘汰算法创建并发安全的缓存
+func NewSyncCacheWithPolicy(policy string, cacheBytes int64) (*SyncCache, error) {
+	c, err := NewCache(policy, cacheBytes)
+	if err != nil {
+		return nil, err
+	}
+	return NewSyncCache(c), nil
+}
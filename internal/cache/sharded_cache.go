@@ -0,0 +1,160 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cache
+
+import (
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"hash/fnv"
+	"time"
+)
+
+var (
+	_ cache.Cache   = (*ShardedSyncCache)(nil)
+	_ Janitor       = (*ShardedSyncCache)(nil)
+	_ KeyExpirer    = (*ShardedSyncCache)(nil)
+	_ cache.Evicter = (*ShardedSyncCache)(nil)
+	_ cache.Stater  = (*ShardedSyncCache)(nil)
+	_ cache.Ranger  = (*ShardedSyncCache)(nil)
+)
+
+// ShardedSyncCache 分片缓存,每个分片独立加锁,按key的哈希选择分片
+type ShardedSyncCache struct {
+	shards  []*SyncCache
+	janitor janitor
+}
+
+// NewShardedSyncCache 创建分片缓存,每个分片平分cacheBytes
+func NewShardedSyncCache(cacheBytes int64, shards int, newCache func(maxBytes int64) cache.Cache) *ShardedSyncCache {
+	if shards <= 0 {
+		shards = consts.DefaultShards
+	}
+	s := &ShardedSyncCache{shards: make([]*SyncCache, shards)}
+	shardBytes := cacheBytes / int64(shards)
+	if cacheBytes != 0 && shardBytes == 0 {
+		shardBytes = 1
+	}
+	for i := range s.shards {
+		s.shards[i] = NewSyncCache(newCache(shardBytes))
+	}
+	return s
+}
+
+// NewShardedSyncCacheDefault 创建使用LRU算法的分片缓存
+func NewShardedSyncCacheDefault(cacheBytes int64, shards int) *ShardedSyncCache {
+	return NewShardedSyncCache(cacheBytes, shards, func(maxBytes int64) cache.Cache {
+		return lru.NewLRUCache(maxBytes, nil)
+	})
+}
+
+// shard 获取key所在分片
+func (s *ShardedSyncCache) shard(key string) *SyncCache {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *ShardedSyncCache) Get(key string) (value cache.Valuer, ok bool) {
+	return s.shard(key).Get(key)
+}
+
+// Len 缓存列表的条数
+func (s *ShardedSyncCache) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Add 新建数据
+func (s *ShardedSyncCache) Add(key string, value cache.Valuer) {
+	s.shard(key).Add(key, value)
+}
+
+// Remove 移除指定key的数据
+func (s *ShardedSyncCache) Remove(key string) {
+	s.shard(key).Remove(key)
+}
+
+// Evict 从占用内存最多的分片中淘汰一条记录
+func (s *ShardedSyncCache) Evict() bool {
+	var target *SyncCache
+	var heaviest int64 = -1
+	for _, shard := range s.shards {
+		if bytes := shard.Stats().Bytes; shard.Len() > 0 && bytes > heaviest {
+			target, heaviest = shard, bytes
+		}
+	}
+	return target != nil && target.Evict()
+}
+
+// Expire 删除已过期的key
+func (s *ShardedSyncCache) Expire(key string) {
+	s.shard(key).Expire(key)
+}
+
+func (s *ShardedSyncCache) Clear() {
+	for _, shard := range s.shards {
+		shard.Clear()
+	}
+}
+
+// RemoveExpired 逐个分片删除已过期的数据
+func (s *ShardedSyncCache) RemoveExpired() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.RemoveExpired()
+	}
+	return n
+}
+
+// StartJanitor 每隔interval清理一次过期数据
+func (s *ShardedSyncCache) StartJanitor(interval time.Duration) {
+	s.janitor.start(interval, func() {
+		s.RemoveExpired()
+	})
+}
+
+// StopJanitor 停止清理过期数据
+func (s *ShardedSyncCache) StopJanitor() {
+	s.janitor.close()
+}
+
+// Range 逐个分片遍历
+func (s *ShardedSyncCache) Range(fn func(key string, value cache.Valuer) bool) {
+	stopped := false
+	for _, shard := range s.shards {
+		shard.Range(func(key string, value cache.Valuer) bool {
+			stopped = !fn(key, value)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// Stats 汇总所有分片的统计
+func (s *ShardedSyncCache) Stats() cache.Stats {
+	var stats cache.Stats
+	for _, shard := range s.shards {
+		stats = stats.Add(shard.Stats())
+	}
+	return stats
+}
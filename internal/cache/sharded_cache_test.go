@@ -0,0 +1,62 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestShardedGet(t *testing.T) {
+	c := NewShardedSyncCacheDefault(int64(0), 4)
+	for i := 0; i < 100; i++ {
+		c.Add("key"+strconv.Itoa(i), String(strconv.Itoa(i)))
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := c.Get("key" + strconv.Itoa(i)); !ok || string(v.(String)) != strconv.Itoa(i) {
+			t.Fatalf("sharded hit key%d failed", i)
+		}
+	}
+	if c.Len() != 100 {
+		t.Fatalf("expected 100 but got %d", c.Len())
+	}
+	c.Remove("key1")
+	if _, ok := c.Get("key1"); ok || c.Len() != 99 {
+		t.Fatalf("sharded remove key1 failed")
+	}
+	c.Clear()
+	if c.Len() != 0 {
+		t.Fatalf("sharded clear failed")
+	}
+}
+
+func TestShardedConcurrentAccess(t *testing.T) {
+	stress(t, NewShardedSyncCacheDefault(4096, 4))
+}
+
+func TestShardedMaxBytes(t *testing.T) {
+	// 每个分片 100 字节
+	c := NewShardedSyncCacheDefault(int64(400), 4)
+	for i := 0; i < 1000; i++ {
+		c.Add("key"+strconv.Itoa(i), String("0123456789"))
+	}
+	for _, shard := range c.shards {
+		if shard.Len() > 100/len("key000"+"0123456789") {
+			t.Fatalf("shard exceeds its byte budget: %d entries", shard.Len())
+		}
+	}
+}
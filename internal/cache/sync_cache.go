@@ -20,32 +20,91 @@ import (
 	"github.com/chenquan/hit/internal/cache/backend/cache"
 	"github.com/chenquan/hit/internal/cache/lru"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var (
+	_ cache.Cache   = (*SyncCache)(nil)
+	_ Janitor       = (*SyncCache)(nil)
+	_ KeyExpirer    = (*SyncCache)(nil)
+	_ cache.Evicter = (*SyncCache)(nil)
+	_ cache.Stater  = (*SyncCache)(nil)
+	_ cache.Ranger  = (*SyncCache)(nil)
+)
+
+// readBufferSize 读缓冲区大小,缓冲区满时批量提升访问记录
+const readBufferSize = 64
+
+// SyncCache 并发安全的缓存.
+// 若底层缓存实现了cache.Peeker,Get只持有读锁查找数据,访问记录(包括未命中)写入读缓冲区,
+// 在下一次持有写锁时(Add/Remove/Clear或缓冲区已满)批量回放,避免并发修改访问队列.
+// 否则Get持有写锁.
 type SyncCache struct {
-	mu sync.RWMutex
-	c  cache.Cache
+	counters counters
+
+	mu     sync.RWMutex
+	c      cache.Cache
+	peeker cache.Peeker
+	reads  chan string // 待回放的访问记录
+
+	janitor janitor
 }
 
 func NewSyncCache(c cache.Cache) *SyncCache {
-	return &SyncCache{
+	s := &SyncCache{
 		c: c,
 	}
+	if peeker, ok := c.(cache.Peeker); ok {
+		s.peeker = peeker
+		s.reads = make(chan string, readBufferSize)
+	}
+	return s
 }
 func NewSyncCacheDefault(cacheBytes int64) *SyncCache {
 	return NewSyncCache(lru.NewLRUCache(cacheBytes, nil))
 }
 
 func (s *SyncCache) Get(key string) (value cache.Valuer, ok bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	if v, ok := s.c.Get(key); ok {
-		return v.(cache.Valuer), ok
+	if s.peeker == nil {
+		s.mu.Lock()
+		value, ok = s.c.Get(key)
+		s.mu.Unlock()
+	} else {
+		s.mu.RLock()
+		value, ok = s.peeker.Peek(key)
+		s.mu.RUnlock()
+		// 未命中同样记录,TinyLFU依赖未命中的访问频率决定是否接纳新数据
+		s.recordRead(key)
 	}
+	s.counters.get(ok)
 	return
 }
 
+// recordRead 记录一次访问,缓冲区已满时由当前协程回放
+func (s *SyncCache) recordRead(key string) {
+	select {
+	case s.reads <- key:
+	default:
+		s.mu.Lock()
+		s.drainReads()
+		s.c.Get(key)
+		s.mu.Unlock()
+	}
+}
+
+// drainReads 回放读缓冲区中的访问记录,调用方必须持有写锁
+func (s *SyncCache) drainReads() {
+	for {
+		select {
+		case key := <-s.reads:
+			s.c.Get(key)
+		default:
+			return
+		}
+	}
+}
+
 // Len 缓存列表的条数
 func (s *SyncCache) Len() int {
 	s.mu.RLock()
@@ -59,7 +118,9 @@ func (s *SyncCache) Add(key string, value cache.Valuer) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.drainReads()
 	s.c.Add(key, value)
+	atomic.AddInt64(&s.counters.sets, 1)
 }
 
 // 移除指定key的数据
@@ -67,11 +128,138 @@ func (s *SyncCache) Remove(key string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.drainReads()
+	if s.remove(key) {
+		atomic.AddInt64(&s.counters.deletes, 1)
+	}
+}
+
+// remove 删除key,返回key是否存在.调用方必须持有写锁
+func (s *SyncCache) remove(key string) bool {
+	n := s.c.Len()
 	s.c.Remove(key)
+	return s.c.Len() < n
+}
+
+// Evict 按底层缓存的淘汰算法淘汰一条记录,底层缓存未实现cache.Evicter时返回false
+func (s *SyncCache) Evict() bool {
+	evicter, ok := s.c.(cache.Evicter)
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drainReads()
+	return evicter.Evict()
+}
+
+// Expire 删除已过期的key
+func (s *SyncCache) Expire(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drainReads()
+	if s.remove(key) {
+		atomic.AddInt64(&s.counters.expirations, 1)
+	}
 }
 func (s *SyncCache) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.drainReads()
 	s.c.Clear()
 }
+
+// RemoveExpired 删除已过期的数据,底层缓存未实现cache.Expirer时不做处理
+func (s *SyncCache) RemoveExpired() int {
+	expirer, ok := s.c.(cache.Expirer)
+	if !ok {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drainReads()
+	return expirer.RemoveExpired(time.Now().Unix())
+}
+
+// StartJanitor 每隔interval清理一次过期数据
+func (s *SyncCache) StartJanitor(interval time.Duration) {
+	s.janitor.start(interval, func() {
+		s.RemoveExpired()
+	})
+}
+
+// StopJanitor 停止清理过期数据
+func (s *SyncCache) StopJanitor() {
+	s.janitor.close()
+}
+
+// Range 在读锁下复制所有数据后遍历,fn中可以修改缓存.底层缓存未实现cache.Ranger时不做处理
+func (s *SyncCache) Range(fn func(key string, value cache.Valuer) bool) {
+	ranger, ok := s.c.(cache.Ranger)
+	if !ok {
+		return
+	}
+	var keys []string
+	var values []cache.Valuer
+	s.mu.RLock()
+	ranger.Range(func(key string, value cache.Valuer) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+	s.mu.RUnlock()
+
+	for i, key := range keys {
+		if !fn(key, values[i]) {
+			return
+		}
+	}
+}
+
+// Stats 缓存统计
+func (s *SyncCache) Stats() cache.Stats {
+	stats := s.counters.stats()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if stater, ok := s.c.(cache.Stater); ok {
+		stats = stats.Add(stater.Stats())
+	} else {
+		stats.Items = int64(s.c.Len())
+	}
+	return stats
+}
+
+// counters 访问计数,使用原子操作更新
+type counters struct {
+	gets        int64
+	hits        int64
+	misses      int64
+	sets        int64
+	deletes     int64
+	expirations int64
+}
+
+func (c *counters) get(hit bool) {
+	atomic.AddInt64(&c.gets, 1)
+	if hit {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+}
+
+func (c *counters) stats() cache.Stats {
+	return cache.Stats{
+		Gets:        atomic.LoadInt64(&c.gets),
+		Hits:        atomic.LoadInt64(&c.hits),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Sets:        atomic.LoadInt64(&c.sets),
+		Deletes:     atomic.LoadInt64(&c.deletes),
+		Expirations: atomic.LoadInt64(&c.expirations),
+	}
+}
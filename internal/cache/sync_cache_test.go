@@ -17,7 +17,15 @@
 package cache
 
 import (
+	"github.com/chenquan/hit/internal/cache/arc"
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lfu"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/cache/tinylfu"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 type String string
@@ -68,3 +76,232 @@ func TestRemoveoldest(t *testing.T) {
 		t.Fatalf("Removeoldest key1 failed")
 	}
 }
+
+func TestLFU(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	capSize := len(k1 + k2 + v1 + v2)
+	c := NewSyncCache(lfu.NewLFUCache(int64(capSize), nil))
+	c.Add(k1, String(v1))
+	c.Add(k2, String(v2))
+	c.Get(k1)
+	c.Add(k3, String(v3))
+
+	if _, ok := c.Get(k2); ok || c.Len() != 2 {
+		t.Fatalf("RemoveLeast key2 failed")
+	}
+}
+
+func TestBufferedPromotion(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	capSize := len(k1 + k2 + v1 + v2)
+	lru := NewSyncCacheDefault(int64(capSize))
+	lru.Add(k1, String(v1))
+	lru.Add(k2, String(v2))
+	// 访问记录先进入读缓冲区,在Add时回放
+	lru.Get(k1)
+	lru.Add(k3, String(v3))
+
+	if _, ok := lru.Get(k2); ok {
+		t.Fatalf("buffered promotion of key1 failed")
+	}
+	if _, ok := lru.Get(k1); !ok {
+		t.Fatalf("key1 should survive after promotion")
+	}
+}
+
+// recordCache 记录回放到底层缓存的访问
+type recordCache struct {
+	*lru.Cache
+	gets []string
+}
+
+func (c *recordCache) Get(key string) (cache.Valuer, bool) {
+	c.gets = append(c.gets, key)
+	return c.Cache.Get(key)
+}
+
+func TestBufferedMiss(t *testing.T) {
+	rc := &recordCache{Cache: lru.NewLRUCache(1024, nil)}
+	c := NewSyncCache(rc)
+	c.Add("key1", String("value1"))
+	c.Get("key1")
+	c.Get("missing")
+	c.Add("key2", String("value2"))
+
+	if len(rc.gets) != 2 || rc.gets[0] != "key1" || rc.gets[1] != "missing" {
+		t.Fatalf("expected buffered hit and miss to be replayed, got %v", rc.gets)
+	}
+}
+
+// TestConcurrentAccess 需配合 go test -race 运行
+func TestConcurrentAccess(t *testing.T) {
+	backends := map[string]func() *SyncCache{
+		"lru":     func() *SyncCache { return NewSyncCacheDefault(1024) },
+		"lfu":     func() *SyncCache { return NewSyncCache(lfu.NewLFUCache(1024, nil)) },
+		"arc":     func() *SyncCache { return NewSyncCache(arc.NewARCCache(1024, nil)) },
+		"tinylfu": func() *SyncCache { return NewSyncCache(tinylfu.NewTinyLFUCache(1024, nil)) },
+	}
+	for name, newCache := range backends {
+		t.Run(name, func(t *testing.T) {
+			stress(t, newCache())
+		})
+	}
+}
+
+func stress(t *testing.T, c cache.Cache) {
+	for i := 0; i < 32; i++ {
+		c.Add("key"+strconv.Itoa(i), String("value"))
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 2000; i++ {
+				key := "key" + strconv.Itoa((g*7+i)%64)
+				switch i % 50 {
+				case 0:
+					c.Add(key, String("value"))
+				case 1:
+					c.Remove(key)
+				default:
+					if v, ok := c.Get(key); ok && string(v.(String)) != "value" {
+						t.Errorf("unexpected value %s", v)
+					}
+				}
+			}
+		}(g)
+	}
+	close(start)
+	wg.Wait()
+	if c.Len() > 64 {
+		t.Fatalf("unexpected len %d", c.Len())
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	c := NewSyncCacheDefault(int64(0))
+	c.Add("key1", lru.NewValue([]byte("v1"), time.Now().Unix()-1, "group"))
+	c.Add("key2", lru.NewValue([]byte("v2"), time.Now().Add(time.Hour).Unix(), "group"))
+	c.StartJanitor(time.Millisecond * 10)
+	defer c.StopJanitor()
+
+	deadline := time.Now().Add(time.Second)
+	for c.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("janitor did not remove expired key1")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if _, ok := c.Get("key2"); !ok {
+		t.Fatalf("live key2 should not be removed")
+	}
+}
+
+func TestExpiredPolicies(t *testing.T) {
+	backends := map[string]func(maxBytes int64) cache.Cache{
+		"lru":     func(maxBytes int64) cache.Cache { return lru.NewLRUCache(maxBytes, nil) },
+		"lfu":     func(maxBytes int64) cache.Cache { return lfu.NewLFUCache(maxBytes, nil) },
+		"arc":     func(maxBytes int64) cache.Cache { return arc.NewARCCache(maxBytes, nil) },
+		"tinylfu": func(maxBytes int64) cache.Cache { return tinylfu.NewTinyLFUCache(maxBytes, nil) },
+	}
+	past, future := time.Now().Unix()-1, time.Now().Add(time.Hour).Unix()
+	for name, newBackend := range backends {
+		// 所有淘汰算法都支持后台删除过期数据
+		c := NewSyncCache(newBackend(0))
+		c.Add("key1", lru.NewValue([]byte("v1"), past, "group"))
+		c.Add("key2", lru.NewValue([]byte("v2"), future, "group"))
+		c.Add("key3", lru.NewValue([]byte("v3"), 0, "group"))
+		if n := c.RemoveExpired(); n != 1 || c.Len() != 2 {
+			t.Fatalf("%s: expected 1 expired but got %d", name, n)
+		}
+		if stats := c.Stats(); stats.Expirations != 1 {
+			t.Fatalf("%s: expected expirations to be counted but got %+v", name, stats)
+		}
+
+		// 主动淘汰时优先淘汰已过期的记录
+		c = NewSyncCache(newBackend(0))
+		c.Add("live", lru.NewValue([]byte("v"), future, "group"))
+		c.Add("expired", lru.NewValue([]byte("v"), past, "group"))
+		c.Get("expired")
+		if !c.Evict() {
+			t.Fatalf("%s: expected an eviction", name)
+		}
+		if _, ok := c.Get("live"); !ok {
+			t.Fatalf("%s: expected expired key to be evicted first", name)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	capSize := len(k1 + k2 + v1 + v2)
+	c := NewSyncCacheDefault(int64(capSize))
+	c.Add(k1, String(v1))
+	c.Add(k2, String(v2))
+	c.Add(k3, String(v3))
+	c.Get(k1)
+	c.Get(k2)
+	c.Remove(k2)
+	c.Expire(k3)
+	// 不存在的key不计入删除与过期次数
+	c.Remove(k2)
+	c.Expire(k3)
+
+	expect := cache.Stats{
+		Gets:        2,
+		Hits:        1,
+		Misses:      1,
+		Sets:        3,
+		Deletes:     1,
+		Evictions:   1,
+		Expirations: 1,
+		Bytes:       0,
+		Items:       0,
+	}
+	if stats := c.Stats(); stats != expect {
+		t.Fatalf("expected %+v but got %+v", expect, stats)
+	}
+}
+
+func TestRange(t *testing.T) {
+	caches := map[string]cache.Cache{
+		"lru":     NewSyncCacheDefault(0),
+		"lfu":     NewSyncCache(lfu.NewLFUCache(0, nil)),
+		"arc":     NewSyncCache(arc.NewARCCache(0, nil)),
+		"tinylfu": NewSyncCache(tinylfu.NewTinyLFUCache(1<<20, nil)),
+		"sharded": NewShardedSyncCacheDefault(0, 4),
+	}
+	for name, c := range caches {
+		for i := 0; i < 10; i++ {
+			c.Add("key"+strconv.Itoa(i), String(strconv.Itoa(i)))
+		}
+		got := make(map[string]string)
+		c.(cache.Ranger).Range(func(key string, value cache.Valuer) bool {
+			got[key] = value.String()
+			return true
+		})
+		if len(got) != 10 {
+			t.Fatalf("%s: expected 10 entries but got %d", name, len(got))
+		}
+		for key, value := range got {
+			if key != "key"+value {
+				t.Fatalf("%s: unexpected entry %s=%s", name, key, value)
+			}
+		}
+
+		n := 0
+		c.(cache.Ranger).Range(func(key string, value cache.Valuer) bool {
+			n++
+			return n < 3
+		})
+		if n != 3 {
+			t.Fatalf("%s: expected Range to stop after 3 entries but got %d", name, n)
+		}
+	}
+}
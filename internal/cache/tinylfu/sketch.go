@@ -0,0 +1,90 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package tinylfu
+
+import "hash/fnv"
+
+const (
+	sketchDepth   = 4  // 哈希函数个数
+	sketchMaxFreq = 15 // 计数上限(4bit)
+)
+
+// sketch Count-Min Sketch 频率估算器,周期性衰减
+type sketch struct {
+	width     uint64
+	rows      [sketchDepth][]uint8
+	additions int // 自上次衰减以来的计数次数
+	resetAt   int // 达到该次数后所有计数减半
+}
+
+func newSketch(width int) *sketch {
+	w := uint64(1)
+	for w < uint64(width) {
+		w <<= 1
+	}
+	s := &sketch{width: w, resetAt: int(w) * 10}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// hash 由一个64位哈希派生出每行的下标
+func (s *sketch) hash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum, (sum >> 32) | 1
+}
+
+// Increment 增加key的访问计数
+func (s *sketch) Increment(key string) {
+	h1, h2 := s.hash(key)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & (s.width - 1)
+		if s.rows[i][idx] < sketchMaxFreq {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// Estimate 估算key的访问频率
+func (s *sketch) Estimate(key string) uint8 {
+	h1, h2 := s.hash(key)
+	lowest := uint8(sketchMaxFreq)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & (s.width - 1)
+		if s.rows[i][idx] < lowest {
+			lowest = s.rows[i][idx]
+		}
+	}
+	return lowest
+}
+
+// reset 所有计数减半,使旧的热点逐渐失效
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
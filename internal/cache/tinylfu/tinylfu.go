@@ -0,0 +1,367 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// W-TinyLFU: 新记录先进入窗口LRU,从窗口淘汰的记录只有在估算频率高于
+// 主缓存(分段LRU)的淘汰者时才会被接纳,避免一次性扫描冲刷热点数据.
+
+package tinylfu
+
+import (
+	"container/list"
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/expiry"
+)
+
+const (
+	windowPercent       = 1       // 窗口LRU占总内存的百分比
+	protectedPercent    = 80      // 保护段占主缓存的百分比
+	estimatedEntryBytes = 64      // 估算单条记录大小,用于确定频率估算器的宽度
+	minSketchWidth      = 64      // 频率估算器最小宽度
+	maxSketchWidth      = 1 << 20 // 频率估算器最大宽度
+)
+
+// 记录所在的段
+const (
+	segmentWindow = iota
+	segmentProbation
+	segmentProtected
+)
+
+type entry struct {
+	key     string
+	value   cache.Valuer
+	segment int
+	item    expiry.Item // 到期索引
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+type Cache struct {
+	maxBytes       int64                                // 最大内存
+	windowBytes    int64                                // 窗口LRU当前内存
+	probationBytes int64                                // 试用段当前内存
+	protectedBytes int64                                // 保护段当前内存
+	window         *list.List                           // 窗口LRU
+	probation      *list.List                           // 主缓存试用段
+	protected      *list.List                           // 主缓存保护段
+	cache          map[string]*list.Element             // 缓存字典
+	sketch         *sketch                              // 频率估算器
+	expiry         expiry.Heap                          // 到期索引
+	evictions      int64                                // 因内存不足淘汰的条数
+	expirations    int64                                // 因过期删除的条数
+	OnEvicted      func(key string, value cache.Valuer) // (可选)移除缓存中某条记录时执行
+}
+
+// NewTinyLFUCache 创建W-TinyLFU缓存
+func NewTinyLFUCache(maxBytes int64, onEvicted func(string, cache.Valuer)) *Cache {
+	width := maxBytes / estimatedEntryBytes
+	if width < minSketchWidth {
+		width = minSketchWidth
+	} else if width > maxSketchWidth {
+		width = maxSketchWidth
+	}
+	return &Cache{
+		maxBytes:  maxBytes,
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		cache:     make(map[string]*list.Element),
+		sketch:    newSketch(int(width)),
+		OnEvicted: onEvicted,
+	}
+}
+
+func (c *Cache) windowMaxBytes() int64 {
+	return c.maxBytes * windowPercent / 100
+}
+
+func (c *Cache) mainMaxBytes() int64 {
+	return c.maxBytes - c.windowMaxBytes()
+}
+
+func (c *Cache) protectedMaxBytes() int64 {
+	return c.mainMaxBytes() * protectedPercent / 100
+}
+
+// Len 缓存列表的条数
+func (c *Cache) Len() int {
+	if c.cache == nil {
+		return 0
+	}
+	return len(c.cache)
+}
+
+// Add 添加一个值到缓存中
+func (c *Cache) Add(key string, value cache.Valuer) {
+	if c.cache == nil {
+		c.cache = make(map[string]*list.Element)
+		c.window = list.New()
+		c.probation = list.New()
+		c.protected = list.New()
+	}
+	c.sketch.Increment(key)
+
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		c.addBytes(kv.segment, int64(value.Len())-int64(kv.value.Len()))
+		kv.value = value
+		c.expiry.Update(&kv.item, value.Expire())
+		c.touch(ele)
+	} else {
+		kv := &entry{key: key, value: value, segment: segmentWindow, item: expiry.Item{Key: key}}
+		c.cache[key] = c.window.PushFront(kv)
+		c.windowBytes += kv.size()
+		c.expiry.Update(&kv.item, value.Expire())
+	}
+
+	// 超出内存上限时优先删除已过期的记录
+	now := expiry.Now()
+	for c.maxBytes != 0 && c.windowBytes+c.probationBytes+c.protectedBytes > c.maxBytes && c.removeExpired(now) {
+	}
+
+	for c.maxBytes != 0 && c.windowBytes > c.windowMaxBytes() {
+		c.admit(c.window.Back())
+	}
+	c.balanceProtected()
+	for c.maxBytes != 0 && c.probationBytes+c.protectedBytes > c.mainMaxBytes() {
+		c.removeElement(c.victim())
+		c.evictions++
+	}
+}
+
+// Get 查找键的值
+func (c *Cache) Get(key string) (value cache.Valuer, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	c.sketch.Increment(key)
+	if ele, ok := c.cache[key]; ok {
+		c.touch(ele)
+		c.balanceProtected()
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
+// Peek 查找键的值,不更新访问记录
+func (c *Cache) Peek(key string) (value cache.Valuer, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, ok := c.cache[key]; ok {
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
+// touch 记录一次访问
+func (c *Cache) touch(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	switch kv.segment {
+	case segmentWindow:
+		c.window.MoveToFront(ele)
+	case segmentProbation:
+		// 试用段再次被访问,晋升到保护段
+		c.probation.Remove(ele)
+		c.probationBytes -= kv.size()
+		kv.segment = segmentProtected
+		c.cache[kv.key] = c.protected.PushFront(kv)
+		c.protectedBytes += kv.size()
+	case segmentProtected:
+		c.protected.MoveToFront(ele)
+	}
+}
+
+// balanceProtected 保护段超出上限时,将最久未访问的记录降级到试用段
+func (c *Cache) balanceProtected() {
+	for c.maxBytes != 0 && c.protectedBytes > c.protectedMaxBytes() {
+		ele := c.protected.Back()
+		kv := ele.Value.(*entry)
+		c.protected.Remove(ele)
+		c.protectedBytes -= kv.size()
+		kv.segment = segmentProbation
+		c.cache[kv.key] = c.probation.PushFront(kv)
+		c.probationBytes += kv.size()
+	}
+}
+
+// admit 窗口淘汰的候选者与主缓存淘汰者比较频率,决定是否进入主缓存
+func (c *Cache) admit(ele *list.Element) {
+	candidate := ele.Value.(*entry)
+	c.window.Remove(ele)
+	c.windowBytes -= candidate.size()
+
+	mainBytes := c.probationBytes + c.protectedBytes
+	if candidate.size() > c.mainMaxBytes() {
+		c.evict(candidate)
+		c.evictions++
+		return
+	}
+	if mainBytes+candidate.size() > c.mainMaxBytes() {
+		victim := c.victim()
+		if victim != nil && c.sketch.Estimate(candidate.key) <= c.sketch.Estimate(victim.Value.(*entry).key) {
+			c.evict(candidate)
+			c.evictions++
+			return
+		}
+	}
+	candidate.segment = segmentProbation
+	c.cache[candidate.key] = c.probation.PushFront(candidate)
+	c.probationBytes += candidate.size()
+}
+
+// victim 主缓存中下一个被淘汰的记录
+func (c *Cache) victim() *list.Element {
+	if ele := c.probation.Back(); ele != nil {
+		return ele
+	}
+	return c.protected.Back()
+}
+
+// Evict 淘汰主缓存的下一个淘汰者,主缓存为空时淘汰窗口中最久未访问的记录,优先淘汰已过期的记录
+func (c *Cache) Evict() bool {
+	if c.Len() == 0 {
+		return false
+	}
+	if c.removeExpired(expiry.Now()) {
+		return true
+	}
+	ele := c.victim()
+	if ele == nil {
+		ele = c.window.Back()
+	}
+	c.removeElement(ele)
+	c.evictions++
+	return true
+}
+
+// RemoveExpired 删除到期时间不晚于timestamp的记录,返回删除条数
+func (c *Cache) RemoveExpired(timestamp int64) int {
+	if c.cache == nil {
+		return 0
+	}
+	n := 0
+	for c.removeExpired(timestamp) {
+		n++
+	}
+	return n
+}
+
+// removeExpired 删除一条到期时间不晚于timestamp的记录,没有时返回false
+func (c *Cache) removeExpired(timestamp int64) bool {
+	item := c.expiry.PeekExpired(timestamp)
+	if item == nil {
+		return false
+	}
+	c.removeElement(c.cache[item.Key])
+	c.expirations++
+	return true
+}
+
+func (c *Cache) addBytes(segment int, n int64) {
+	switch segment {
+	case segmentWindow:
+		c.windowBytes += n
+	case segmentProbation:
+		c.probationBytes += n
+	case segmentProtected:
+		c.protectedBytes += n
+	}
+}
+
+func (c *Cache) removeElement(e *list.Element) {
+	if e == nil {
+		return
+	}
+	kv := e.Value.(*entry)
+	switch kv.segment {
+	case segmentWindow:
+		c.window.Remove(e)
+	case segmentProbation:
+		c.probation.Remove(e)
+	case segmentProtected:
+		c.protected.Remove(e)
+	}
+	c.addBytes(kv.segment, -kv.size())
+	c.evict(kv)
+}
+
+// evict 从字典中删除并通知
+func (c *Cache) evict(kv *entry) {
+	c.expiry.Remove(&kv.item)
+	delete(c.cache, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// Remove 移除指定key的数据
+func (c *Cache) Remove(key string) {
+	if c.cache == nil {
+		return
+	}
+	if ele, hit := c.cache[key]; hit {
+		c.removeElement(ele)
+	}
+}
+
+// Clear 清空全部数据
+func (c *Cache) Clear() {
+	if c.cache == nil {
+		return
+	}
+	if c.OnEvicted != nil {
+		for _, e := range c.cache {
+			kv := e.Value.(*entry)
+			c.OnEvicted(kv.key, kv.value)
+		}
+	}
+	c.window = nil
+	c.probation = nil
+	c.protected = nil
+	c.cache = nil
+	c.expiry.Clear()
+	c.windowBytes = 0
+	c.probationBytes = 0
+	c.protectedBytes = 0
+}
+
+// Range 依次遍历窗口LRU、试用段与保护段中的记录
+func (c *Cache) Range(fn func(key string, value cache.Valuer) bool) {
+	if c.cache == nil {
+		return
+	}
+	for _, l := range []*list.List{c.window, c.probation, c.protected} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			kv := e.Value.(*entry)
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+	}
+}
+
+// Stats 淘汰与内存统计
+func (c *Cache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.windowBytes + c.probationBytes + c.protectedBytes,
+		Items:       int64(c.Len()),
+	}
+}
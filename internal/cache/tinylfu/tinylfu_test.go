@@ -0,0 +1,124 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package tinylfu
+
+import (
+	"github.com/chenquan/hit/internal/cache/backend/cache"
+	"strconv"
+	"testing"
+)
+
+type String string
+
+func (s String) Bytes() []byte {
+	return []byte(s)
+}
+
+func (s String) Len() int {
+	return len(s)
+}
+func (s String) Expire() int64 {
+	return 0
+}
+func (s String) SetExpire(timestamp int64) {
+}
+func (s String) GroupName() string {
+	return ""
+}
+func (s String) String() string {
+	return string(s)
+}
+
+func TestGet(t *testing.T) {
+	c := NewTinyLFUCache(int64(0), nil)
+	c.Add("key1", String("1234"))
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("tinylfu hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("tinylfu miss key2 failed")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	c := NewTinyLFUCache(int64(0), nil)
+	c.Add("key", String("1"))
+	c.Add("key", String("111"))
+
+	bytes := c.windowBytes + c.probationBytes + c.protectedBytes
+	if bytes != int64(len("key")+len("111")) {
+		t.Fatal("expected 6 but got", bytes)
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	evicted := 0
+	c := NewTinyLFUCache(int64(1000), func(key string, value cache.Valuer) {
+		evicted++
+	})
+	hot := make([]string, 10)
+	for i := range hot {
+		hot[i] = "hot" + strconv.Itoa(i)
+		c.Add(hot[i], String("0123456789"))
+	}
+	for n := 0; n < 5; n++ {
+		for _, key := range hot {
+			c.Get(key)
+		}
+	}
+	// 一次性扫描
+	for i := 0; i < 1000; i++ {
+		c.Add("scan"+strconv.Itoa(i), String("0123456789"))
+	}
+	for _, key := range hot {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("hot key %s was evicted by scan", key)
+		}
+	}
+	if c.windowBytes+c.probationBytes+c.protectedBytes > 1000 {
+		t.Fatalf("cache exceeds max bytes")
+	}
+	if evicted == 0 || c.Len()+evicted != len(hot)+1000 {
+		t.Fatalf("unexpected evicted count %d, len %d", evicted, c.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := NewTinyLFUCache(int64(100), nil)
+	c.Add("key1", String("1"))
+	c.Add("key2", String("2"))
+	c.Get("key1")
+	c.Remove("key1")
+	c.Remove("key2")
+	if c.Len() != 0 || c.windowBytes+c.probationBytes+c.protectedBytes != 0 {
+		t.Fatalf("Remove failed")
+	}
+}
+
+func TestSketchReset(t *testing.T) {
+	s := newSketch(minSketchWidth)
+	for i := 0; i < 10; i++ {
+		s.Increment("key")
+	}
+	if s.Estimate("key") != 10 {
+		t.Fatalf("expected 10 but got %d", s.Estimate("key"))
+	}
+	s.reset()
+	if s.Estimate("key") != 5 {
+		t.Fatalf("expected 5 but got %d", s.Estimate("key"))
+	}
+}
@@ -108,3 +108,30 @@ func (m *Map) Get(key string) string {
 
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// GetN 沿哈希环顺时针获取与键最接近的至多n个不同的项,第一个即为Get的结果
+func (m *Map) GetN(key string, n int) []string {
+	m.rwm.RLock()
+	defer m.rwm.RUnlock()
+
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	items := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	for i := 0; i < len(m.keys) && len(items) < n; i++ {
+		item := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if _, ok := seen[item]; ok {
+			continue
+		}
+		seen[item] = struct{}{}
+		items = append(items, item)
+	}
+	return items
+}
@@ -17,6 +17,7 @@
 package consistenthash
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -66,3 +67,29 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := []struct {
+		key    string
+		n      int
+		expect []string
+	}{
+		{"11", 2, []string{"2", "4"}},
+		{"15", 3, []string{"6", "2", "4"}},
+		{"27", 5, []string{"2", "4", "6"}},
+		{"23", 1, []string{hash.Get("23")}},
+		{"23", 0, nil},
+	}
+	for _, testCase := range testCases {
+		if got := hash.GetN(testCase.key, testCase.n); !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("GetN(%s, %d): expected %v but got %v", testCase.key, testCase.n, testCase.expect, got)
+		}
+	}
+}
@@ -21,16 +21,62 @@ import "time"
 // 默认路径
 const (
 	DefaultEctdPath           = "hit/"
+	DefaultInvalidatePath     = "hit-invalidate/" // 本地缓存失效事件的etcd路径,不能以DefaultEctdPath开头
 	ContentType               = "application/octet-stream"
-	DefaultLocalCacheDuration = time.Second * 10 // 默认本地缓存时长
-	DefaultNodeCacheDuration  = time.Second * 60 // 默认节点缓存时长
-	DefaultBasePath           = "/hit"           // 默认基础URL路径
-	DefaultPost               = "2020"           // 默认端口
+	DefaultLocalCacheDuration = time.Second * 10      // 默认本地缓存时长
+	DefaultNodeCacheDuration  = time.Second * 60      // 默认节点缓存时长
+	DefaultNodeTimeout        = time.Second * 3       // 默认访问节点超时时间
+	DefaultNodeAttempts       = 1                     // 默认访问节点失败时最多尝试的节点数
+	DefaultNodeBackoff        = time.Millisecond * 20 // 默认重试下一个节点前的等待时间,逐次翻倍
+	DefaultNodesFileInterval  = time.Second * 2       // 检查节点列表文件是否变化的间隔
+	DefaultNodeCloseDelay     = time.Second * 30      // 节点移除后等待处理中的请求完成再关闭连接的时间
+	DefaultVirtualNodes       = 10                    // 每个节点在哈希环上的默认虚拟节点个数
+	DefaultBasePath           = "/hit"                // 默认基础URL路径
+	DefaultMetricsPath        = "/metrics"            // 默认指标URL路径
+	DefaultPost               = "2020"                // 默认端口
+	DefaultShards             = 32                    // 默认缓存分片数
+	DefaultJanitorInterval    = time.Second * 5       // 默认过期数据清理间隔
+	DefaultGroupCacheBytes    = 1000                  // 自动创建分组的默认缓存大小
+	DefaultOriginTimeout      = time.Second * 3       // 默认访问源站超时时间
+	DefaultOriginMaxBytes     = 16 << 20              // 源站响应体的最大大小
+	DefaultRegisterBackoff    = time.Second           // 租约失效后重新注册节点失败时的等待时间,逐次翻倍
+	DefaultRegisterMaxBackoff = time.Second * 30      // 重新注册节点的最大等待时间
+	DefaultAppendSegmentBytes = 64 << 20              // 追加日志单个日志段的最大大小
+	DefaultAppendCompactBytes = 64 << 20              // 上次压缩后追加日志超过该大小时触发压缩
+
+	DefaultInvalidateTTL           = time.Second * 10 // 本地缓存失效事件在etcd中的保留时长
+	DefaultInvalidateRetryInterval = time.Second      // 监听失效事件中断后重新监听的等待时间
+)
+
+// 批量操作,请求路径为 /<basepath>/<groupname>?batch=<op>
+const (
+	BatchQuery = "batch"
+	BatchGet   = "get"
+	BatchSet   = "set"
+	BatchDel   = "del"
 )
 
 // 协议
 const (
 	ProtocolHTTP        = "http"
 	ProtocolHTTPS       = "https"
+	ProtocolGRPC        = "grpc"
 	ProtocolDefaultHTTP = ProtocolHTTP
 )
+
+// 追加日志的fsync策略
+const (
+	FsyncAlways   = "always"   // 每次写入后fsync
+	FsyncEverySec = "everysec" // 每秒fsync一次
+	FsyncNever    = "never"    // 由操作系统决定何时写入磁盘
+	FsyncDefault  = FsyncEverySec
+)
+
+// 缓存淘汰算法
+const (
+	PolicyLRU     = "lru"
+	PolicyLFU     = "lfu"
+	PolicyARC     = "arc"
+	PolicyTinyLFU = "tinylfu"
+	PolicyDefault = PolicyLRU
+)
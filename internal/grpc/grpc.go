@@ -14,4 +14,18 @@
  *    limitations under the License.
  */
 
+// gRPC传输: 服务端注册GroupCache服务,客户端节点见client/grpc
 package grpc
+
+import (
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/chenquan/hit/internal/server"
+	"google.golang.org/grpc"
+)
+
+// NewServer 创建注册了GroupCache服务的gRPC服务端
+func NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	pb.RegisterGroupCacheServer(s, server.NewGroupCacheServer())
+	return s
+}
@@ -0,0 +1,68 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Histogram 累计分桶直方图
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // 各桶上界,升序
+	counts  []uint64  // 落入各桶的次数(非累计)
+	sum     float64
+	count   uint64
+}
+
+// NewHistogram 创建直方图,buckets为升序的桶上界
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// write 按Prometheus文本格式输出,labels形如 method="GET"
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		_, _ = fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	_, _ = fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	_, _ = fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
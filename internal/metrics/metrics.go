@@ -0,0 +1,203 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// 以Prometheus文本格式暴露节点指标
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/chenquan/hit/internal/register"
+	"github.com/chenquan/hit/internal/server"
+	"google.golang.org/grpc"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType Prometheus文本格式
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets 默认请求耗时分桶(秒)
+var DefaultBuckets = []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// LeaseReporter 提供etcd租约状态
+type LeaseReporter interface {
+	LeaseStatus() register.LeaseStatus
+}
+
+// Metrics 节点指标
+type Metrics struct {
+	lease     LeaseReporter
+	mu        sync.RWMutex
+	latencies map[string]*Histogram // key为请求方法
+}
+
+// methodOther 不在统计范围内的HTTP请求方法统一归为other,避免标签无限增长
+const methodOther = "other"
+
+// New 创建节点指标,lease为nil时不输出租约指标
+func New(lease LeaseReporter) *Metrics {
+	return &Metrics{
+		lease:     lease,
+		latencies: make(map[string]*Histogram),
+	}
+}
+
+// Instrument 记录h处理每个请求的耗时
+func (m *Metrics) Instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		m.histogram(httpMethod(r.Method)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// UnaryServerInterceptor 记录每个gRPC请求的耗时,标签为gRPC方法全名
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.histogram(info.FullMethod).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
+
+// httpMethod 节点只处理GET、POST与DELETE请求,其余方法归为other
+func httpMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+		return method
+	}
+	return methodOther
+}
+
+func (m *Metrics) histogram(method string) *Histogram {
+	m.mu.RLock()
+	h, ok := m.latencies[method]
+	m.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok = m.latencies[method]; !ok {
+		h = NewHistogram(DefaultBuckets)
+		m.latencies[method] = h
+	}
+	return h
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	bw := bufio.NewWriter(w)
+	m.writeGroups(bw)
+	m.writeLatencies(bw)
+	m.writeLease(bw)
+	_ = bw.Flush()
+}
+
+// writeGroups 输出各分组的缓存统计
+func (m *Metrics) writeGroups(w *bufio.Writer) {
+	stats := server.Stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := []struct {
+		name, typ, help string
+		value           func(name string) int64
+	}{
+		{"hit_cache_gets_total", "counter", "Number of cache lookups.", func(n string) int64 { return stats[n].Gets }},
+		{"hit_cache_hits_total", "counter", "Number of cache hits.", func(n string) int64 { return stats[n].Hits }},
+		{"hit_cache_misses_total", "counter", "Number of cache misses.", func(n string) int64 { return stats[n].Misses }},
+		{"hit_cache_sets_total", "counter", "Number of cache writes.", func(n string) int64 { return stats[n].Sets }},
+		{"hit_cache_deletes_total", "counter", "Number of cache deletes.", func(n string) int64 { return stats[n].Deletes }},
+		{"hit_cache_evictions_total", "counter", "Number of entries evicted for capacity.", func(n string) int64 { return stats[n].Evictions }},
+		{"hit_cache_expirations_total", "counter", "Number of entries removed after expiring.", func(n string) int64 { return stats[n].Expirations }},
+		{"hit_cache_bytes", "gauge", "Bytes used by cached entries.", func(n string) int64 { return stats[n].Bytes }},
+		{"hit_cache_items", "gauge", "Number of cached entries.", func(n string) int64 { return stats[n].Items }},
+	}
+	for _, metric := range metrics {
+		writeHeader(w, metric.name, metric.typ, metric.help)
+		for _, name := range names {
+			_, _ = fmt.Fprintf(w, "%s{group=\"%s\"} %d\n", metric.name, escape(name), metric.value(name))
+		}
+	}
+}
+
+// writeLatencies 输出各请求方法的耗时分布
+func (m *Metrics) writeLatencies(w *bufio.Writer) {
+	m.mu.RLock()
+	methods := make([]string, 0, len(m.latencies))
+	for method := range m.latencies {
+		methods = append(methods, method)
+	}
+	m.mu.RUnlock()
+	sort.Strings(methods)
+
+	const name = "hit_request_duration_seconds"
+	writeHeader(w, name, "histogram", "Latency of cache requests by method.")
+	for _, method := range methods {
+		m.histogram(method).write(w, name, fmt.Sprintf("method=\"%s\"", escape(method)))
+	}
+}
+
+// writeLease 输出etcd租约状态
+func (m *Metrics) writeLease(w *bufio.Writer) {
+	if m.lease == nil {
+		return
+	}
+	status := m.lease.LeaseStatus()
+	alive := 0
+	if status.Alive {
+		alive = 1
+	}
+	registered := 0
+	if status.Registered {
+		registered = 1
+	}
+	var last int64
+	if !status.LastRenewal.IsZero() {
+		last = status.LastRenewal.Unix()
+	}
+	writeHeader(w, "hit_etcd_lease_alive", "gauge", "Whether the etcd lease keep-alive is running.")
+	_, _ = fmt.Fprintf(w, "hit_etcd_lease_alive %d\n", alive)
+	writeHeader(w, "hit_etcd_lease_renewals_total", "counter", "Number of successful etcd lease renewals.")
+	_, _ = fmt.Fprintf(w, "hit_etcd_lease_renewals_total %d\n", status.Renewals)
+	writeHeader(w, "hit_etcd_lease_last_renewal_timestamp_seconds", "gauge", "Unix time of the last successful etcd lease renewal.")
+	_, _ = fmt.Fprintf(w, "hit_etcd_lease_last_renewal_timestamp_seconds %d\n", last)
+	writeHeader(w, "hit_etcd_registered", "gauge", "Whether the node is registered in etcd.")
+	_, _ = fmt.Fprintf(w, "hit_etcd_registered %d\n", registered)
+	writeHeader(w, "hit_etcd_reregistrations_total", "counter", "Number of times the node re-registered after losing its etcd lease.")
+	_, _ = fmt.Fprintf(w, "hit_etcd_reregistrations_total %d\n", status.Reregistrations)
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// escape 转义标签值
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
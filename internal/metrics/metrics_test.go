@@ -0,0 +1,87 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/register"
+	"github.com/chenquan/hit/internal/server"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type lease struct{}
+
+func (lease) LeaseStatus() register.LeaseStatus {
+	return register.LeaseStatus{Alive: true, Registered: true, Renewals: 3, Reregistrations: 1, LastRenewal: time.Unix(1600000000, 0)}
+}
+
+func TestMetrics(t *testing.T) {
+	group := server.NewGroupDefault("metrics", 0)
+	_ = group.Add("key", lru.NewValue([]byte("value"), time.Now().Add(time.Minute).Unix(), "metrics"))
+	_, _ = group.Get("key")
+	_, _ = group.Get("missing")
+
+	m := New(lease{})
+	h := m.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hit/metrics/key", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOO", "/hit/metrics/key", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BAR", "/hit/metrics/key", nil))
+	_, _ = m.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/remotecache.GroupCache/Get"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	expects := []string{
+		`hit_cache_hits_total{group="metrics"} 1`,
+		`hit_cache_misses_total{group="metrics"} 1`,
+		`hit_cache_sets_total{group="metrics"} 1`,
+		`hit_cache_bytes{group="metrics"} 8`,
+		`hit_request_duration_seconds_count{method="GET"} 1`,
+		`hit_request_duration_seconds_bucket{method="GET",le="+Inf"} 1`,
+		`hit_request_duration_seconds_count{method="other"} 2`,
+		`hit_request_duration_seconds_count{method="/remotecache.GroupCache/Get"} 1`,
+		`hit_etcd_lease_alive 1`,
+		`hit_etcd_lease_renewals_total 3`,
+		`hit_etcd_lease_last_renewal_timestamp_seconds 1600000000`,
+		`hit_etcd_registered 1`,
+		`hit_etcd_reregistrations_total 1`,
+	}
+	for _, expect := range expects {
+		if !strings.Contains(string(body), expect+"\n") {
+			t.Errorf("metrics missing %q in:\n%s", expect, body)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{1, 2})
+	h.Observe(0.5)
+	h.Observe(1.5)
+	h.Observe(3)
+	if h.count != 3 || h.counts[0] != 1 || h.counts[1] != 1 || h.sum != 5 {
+		t.Fatalf("unexpected histogram %+v", h)
+	}
+}
@@ -20,14 +20,20 @@ package register
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/chenquan/hit/internal/consts"
 	"github.com/etcd-io/etcd/clientv3"
 	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// errStopped 已撤销租约,不再申请租约或注册节点
+var errStopped = errors.New("lease revoked")
+
 // 注册
 // 注册
 type Config struct {
@@ -36,8 +42,40 @@ type Config struct {
 	DialTimeout int64    `json:"dial_timeout"` // 超时时间
 	NodeAddr    string   `json:"node_addr"`    // 缓存服务节点地址,列如:192.168.1.11
 	NodeName    string   `json:"node_name"`    // 缓存服务节点名称,例如:node1
-	Protocol    string   `json:"protocol"`     //协议.目前只支持http
+	Protocol    string   `json:"protocol"`     //协议:http,https,grpc.默认:http
 	Port        string   `json:"port"`         //端口.默认:2020
+	MetricsPort string   `json:"metrics_port"` //监控指标端口,grpc协议时使用,为空时不暴露监控指标
+	CertFile    string   `json:"cert_file"`    //节点证书,https协议必填,grpc协议填写时开启TLS
+	KeyFile     string   `json:"key_file"`     //节点证书私钥
+	CAFile      string   `json:"ca_file"`      //CA证书,填写时要求并校验客户端证书(mTLS)
+
+	ShutdownTimeout  int64  `json:"shutdown_timeout"`  // 停止节点时等待处理中请求完成的时长(秒).默认:10
+	SnapshotPath     string `json:"snapshot_path"`     // 快照文件,启动时从中恢复数据,停止时保存.为空时不使用快照
+	SnapshotInterval int64  `json:"snapshot_interval"` // 定时保存快照的间隔(秒),0表示只在停止时保存
+
+	AppendLogDir       string `json:"append_log_dir"`       // 追加日志目录,启动时回放,为空时不开启追加日志
+	AppendFsync        string `json:"append_fsync"`         // 追加日志fsync策略:always,everysec,never.默认:everysec
+	AppendCompactBytes int64  `json:"append_compact_bytes"` // 上次压缩后追加日志超过该大小(字节)时压缩.默认:64MB
+
+	AutoCreate      *bool         `json:"auto_create"`       // 是否自动创建未配置的分组.默认:true
+	AutoCreateBytes int64         `json:"auto_create_bytes"` // 自动创建分组的缓存大小(字节).默认:1000
+	MaxMemory       int64         `json:"max_memory"`        // 所有分组共享的内存上限(字节),0表示不限制
+	MaxGroups       int           `json:"max_groups"`        // 分组数上限,0表示不限制
+	JanitorInterval int64         `json:"janitor_interval"`  // 分组清理过期数据的间隔(秒),小于0表示不清理.默认:5
+	Groups          []GroupConfig `json:"groups"`            // 分组配置
+}
+
+// GroupConfig 分组配置
+type GroupConfig struct {
+	Name       string `json:"name"`        // 分组名称
+	MaxBytes   int64  `json:"max_bytes"`   // 最大内存(字节),0表示不限制
+	DefaultTtl int64  `json:"default_ttl"` // 默认过期时长(秒),小于0表示永不过期.默认:60
+	MaxTtl     int64  `json:"max_ttl"`     // 最大过期时长(秒),0表示不限制
+	Policy     string `json:"policy"`      // 淘汰算法:lru,lfu,arc,tinylfu.默认:lru
+	Weight     int    `json:"weight"`      // 节点内存紧张时按权重分配内存.默认:1
+	// 源站URL模板,{group}与{key}分别替换为分组名与key,未命中时由节点从源站加载.为空时不加载
+	Origin        string `json:"origin"`
+	OriginTimeout int64  `json:"origin_timeout"` // 访问源站超时时间(秒).默认:3
 }
 
 func New(config *Config) *Server {
@@ -53,7 +91,7 @@ func New(config *Config) *Server {
 		fmt.Println("Error Open", etcdConfig.Endpoints, err)
 		os.Exit(0)
 	}
-	client := &Server{client: cli, name: config.NodeName}
+	client := &Server{client: cli, name: config.NodeName, ttl: config.LeaseTtl, stop: make(chan struct{})}
 	if err := client.setLease(config.LeaseTtl); err != nil {
 		fmt.Println(err)
 		os.Exit(0)
@@ -64,11 +102,70 @@ func New(config *Config) *Server {
 }
 
 type Server struct {
-	client        *clientv3.Client
-	leaseResp     *clientv3.LeaseGrantResponse
-	canclefunc    func()
-	keepAliveChan <-chan *clientv3.LeaseKeepAliveResponse
-	name          string
+	renewals        int64 // 续租成功次数
+	lastRenewal     int64 // 最近一次续租成功时间戳
+	leaseAlive      int32 // 续租是否正常,1:正常
+	registered      int32 // 节点是否已注册,1:已注册
+	reregistrations int64 // 租约失效后重新注册成功次数
+	client          *clientv3.Client
+	mu              sync.Mutex // protects leaseResp, canclefunc, keepAliveChan, key, addr, onStateChange
+	leaseResp       *clientv3.LeaseGrantResponse
+	canclefunc      func()
+	keepAliveChan   <-chan *clientv3.LeaseKeepAliveResponse
+	name            string
+	ttl             int64
+	key             string // 注册的etcd key,为空表示未调用RegisterNode
+	addr            string
+	stop            chan struct{} // 撤销租约时关闭,不再重新注册
+	stopOnce        sync.Once
+	onStateChange   func(registered bool)
+}
+
+// LeaseStatus 租约状态
+type LeaseStatus struct {
+	Alive           bool      // 续租是否正常
+	Registered      bool      // 节点是否已注册
+	Renewals        int64     // 续租成功次数
+	Reregistrations int64     // 租约失效后重新注册成功次数
+	LastRenewal     time.Time // 最近一次续租成功时间
+}
+
+// LeaseStatus 获取租约状态
+func (e *Server) LeaseStatus() LeaseStatus {
+	status := LeaseStatus{
+		Alive:           atomic.LoadInt32(&e.leaseAlive) == 1,
+		Registered:      atomic.LoadInt32(&e.registered) == 1,
+		Renewals:        atomic.LoadInt64(&e.renewals),
+		Reregistrations: atomic.LoadInt64(&e.reregistrations),
+	}
+	if last := atomic.LoadInt64(&e.lastRenewal); last != 0 {
+		status.LastRenewal = time.Unix(last, 0)
+	}
+	return status
+}
+
+// OnStateChange 设置注册状态变化时的回调,租约失效时以false调用,重新注册成功后以true调用
+func (e *Server) OnStateChange(fn func(registered bool)) {
+	e.mu.Lock()
+	e.onStateChange = fn
+	e.mu.Unlock()
+}
+
+// setRegistered 更新注册状态并通知
+func (e *Server) setRegistered(registered bool) {
+	var v int32
+	if registered {
+		v = 1
+	}
+	if atomic.SwapInt32(&e.registered, v) == v {
+		return
+	}
+	e.mu.Lock()
+	fn := e.onStateChange
+	e.mu.Unlock()
+	if fn != nil {
+		fn(registered)
+	}
 }
 
 //设置租约
@@ -85,41 +182,138 @@ func (e *Server) setLease(ttl int64) error {
 	leaseRespChan, err := e.client.Lease.KeepAlive(ctx, leaseResp.ID)
 
 	if err != nil {
+		cancelFunc()
 		return err
 	}
 
+	e.mu.Lock()
+	if e.stopped() {
+		// 申请租约期间已撤销租约,释放新申请的租约
+		e.mu.Unlock()
+		cancelFunc()
+		_, _ = e.client.Lease.Revoke(context.TODO(), leaseResp.ID)
+		return errStopped
+	}
+	if e.canclefunc != nil {
+		// 停止旧租约的续租
+		e.canclefunc()
+	}
 	e.leaseResp = leaseResp
 	e.canclefunc = cancelFunc
 	e.keepAliveChan = leaseRespChan
+	e.mu.Unlock()
+	atomic.StoreInt32(&e.leaseAlive, 1)
 	return nil
 }
 
 func (e *Server) ListenLeaseRespChan() {
 	for {
+		e.mu.Lock()
+		keepAliveChan := e.keepAliveChan
+		e.mu.Unlock()
+		for range keepAliveChan {
+			atomic.AddInt64(&e.renewals, 1)
+			atomic.StoreInt64(&e.lastRenewal, time.Now().Unix())
+			log.Printf("续租成功节点:%s.", e.name)
+		}
+		atomic.StoreInt32(&e.leaseAlive, 0)
+		log.Println("已经关闭续租功能.")
+		if e.stopped() {
+			return
+		}
+		// 租约失效(etcd重启、网络分区超过租约时间等),重新注册节点
+		e.setRegistered(false)
+		if !e.reregister() {
+			return
+		}
+	}
+}
+
+// reregister 重新申请租约并注册节点,失败时按退避时间重试,直到成功或撤销租约.
+// 返回false表示已撤销租约
+func (e *Server) reregister() bool {
+	backoff := consts.DefaultRegisterBackoff
+	for {
+		err := e.setLease(e.ttl)
+		if err == nil {
+			err = e.put()
+		}
+		if err == errStopped {
+			return false
+		}
+		if err == nil {
+			atomic.AddInt64(&e.reregistrations, 1)
+			log.Printf("重新注册成功节点:%s.", e.name)
+			return true
+		}
+		log.Printf("重新注册失败节点:%s,%v后重试:%v", e.name, backoff, err)
+
 		select {
-		case leaseKeepResp := <-e.keepAliveChan:
-			if leaseKeepResp == nil {
-				log.Println("已经关闭续租功能.")
-				return
-			} else {
-				log.Printf("续租成功节点:%s.", e.name)
-			}
+		case <-e.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > consts.DefaultRegisterMaxBackoff {
+			backoff = consts.DefaultRegisterMaxBackoff
 		}
 	}
 }
 
+// stopped 是否已撤销租约
+func (e *Server) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
 func (e *Server) RegisterNode(name, addr string) error {
 	name = consts.DefaultEctdPath + name
 	log.Println("注册 name:", name, "addr:", addr)
+	e.mu.Lock()
+	e.key = name
+	e.addr = addr
+	e.mu.Unlock()
+	return e.put()
+}
+
+// put 使用当前租约写入节点key.已撤销租约时不再写入,
+// 写入期间撤销租约时,写入失败或节点key随租约一起删除
+func (e *Server) put() error {
+	e.mu.Lock()
+	key, addr, leaseID := e.key, e.addr, e.leaseResp.ID
+	e.mu.Unlock()
+	if key == "" {
+		return nil
+	}
+	if e.stopped() {
+		return errStopped
+	}
 	kv := clientv3.NewKV(e.client)
-	_, err := kv.Put(context.TODO(), name, addr, clientv3.WithLease(e.leaseResp.ID))
+	_, err := kv.Put(context.TODO(), key, addr, clientv3.WithLease(leaseID))
+	if err == nil && !e.stopped() {
+		e.setRegistered(true)
+	}
 	return err
 }
 
 //撤销租约
 func (e *Server) RevokeLease() error {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+	e.mu.Lock()
 	e.canclefunc()
-	time.Sleep(2 * time.Second)
-	_, err := e.client.Lease.Revoke(context.TODO(), e.leaseResp.ID)
+	leaseID := e.leaseResp.ID
+	e.mu.Unlock()
+	e.setRegistered(false)
+	_, err := e.client.Lease.Revoke(context.TODO(), leaseID)
 	return err
 }
+
+// Close 关闭etcd客户端
+func (e *Server) Close() error {
+	return e.client.Close()
+}
@@ -0,0 +1,556 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 追加日志由目录下按序号命名的日志段(%016d.aof)组成,日志段格式:
+//
+//	magic(6字节) | version(uvarint) | kind(1字节) | record...
+//	record: op(1字节) | group | key | value | expire(varint) | crc32(4字节,大端)
+//
+// group,key,value均为 长度(uvarint)+内容,crc32为该条记录op至expire的校验和,删除记录的value为空.
+// kind为aofBase的日志段由压缩生成,包含压缩时缓存中的全部数据,回放时忽略其之前的日志段
+const (
+	aofMagic   = "HITAOF"
+	aofVersion = 1
+	aofExt     = ".aof"
+
+	aofLog  byte = 0 // 普通日志段
+	aofBase byte = 1 // 压缩生成的日志段
+
+	aofSet byte = 1
+	aofDel byte = 2
+
+	aofKeyLocks = 64 // key锁的分段数
+)
+
+// AppendLog 分组集合的追加日志,记录分组的Add/Delete操作,启动时回放以恢复数据
+type AppendLog struct {
+	groups       *Groups
+	dir          string
+	fsync        string // fsync策略,见consts.Fsync*
+	segmentBytes int64  // 单个日志段的最大大小
+	compactBytes int64  // 上次压缩后追加超过该大小时触发压缩
+
+	mu          sync.Mutex // protects f, seq, segmentSize, logBytes, dirty, closed
+	f           *os.File
+	seq         uint64 // 当前日志段序号
+	segmentSize int64  // 当前日志段大小
+	logBytes    int64  // 上次压缩后追加的字节数
+	dirty       bool   // 是否有未fsync的数据
+	closed      bool
+
+	// 按group与key分段的锁,追加记录与修改缓存在同一把锁内完成,保证同一key的日志顺序与缓存修改顺序一致
+	keyLocks  [aofKeyLocks]sync.Mutex
+	compactMu sync.Mutex // 同一时间只进行一次压缩
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// OpenAppendLog 在默认集合上开启追加日志
+func OpenAppendLog(dir, fsync string, compactBytes int64) (*AppendLog, int, error) {
+	return defaultGroups.OpenAppendLog(dir, fsync, compactBytes)
+}
+
+// OpenAppendLog 回放dir中的追加日志恢复数据,之后分组的Add/Delete操作均追加到日志中,返回回放的记录数.
+// fsync为空时使用consts.FsyncDefault,compactBytes为0时使用consts.DefaultAppendCompactBytes
+func (gs *Groups) OpenAppendLog(dir, fsync string, compactBytes int64) (*AppendLog, int, error) {
+	switch fsync {
+	case "":
+		fsync = consts.FsyncDefault
+	case consts.FsyncAlways, consts.FsyncEverySec, consts.FsyncNever:
+	default:
+		return nil, 0, fmt.Errorf("unknown fsync policy: %s", fsync)
+	}
+	if compactBytes <= 0 {
+		compactBytes = consts.DefaultAppendCompactBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, 0, err
+	}
+	l := &AppendLog{
+		groups:       gs,
+		dir:          dir,
+		fsync:        fsync,
+		segmentBytes: consts.DefaultAppendSegmentBytes,
+		compactBytes: compactBytes,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	n, err := l.replay()
+	if err != nil {
+		return nil, 0, err
+	}
+	l.mu.Lock()
+	err = l.openSegment(l.seq + 1)
+	l.mu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	gs.mu.Lock()
+	gs.appendLog = l
+	gs.mu.Unlock()
+	go l.run()
+	return l, n, nil
+}
+
+// getAppendLog 获取集合的追加日志,未开启时返回nil
+func (gs *Groups) getAppendLog() *AppendLog {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.appendLog
+}
+
+// appendLog 获取分组所属集合的追加日志,未开启时返回nil
+func (g *Group) appendLog() *AppendLog {
+	if g.owner == nil {
+		return nil
+	}
+	return g.owner.getAppendLog()
+}
+
+// Close 停止后台任务,fsync并关闭日志,之后分组的操作不再追加到日志中
+func (l *AppendLog) Close() error {
+	l.groups.mu.Lock()
+	if l.groups.appendLog == l {
+		l.groups.appendLog = nil
+	}
+	l.groups.mu.Unlock()
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	// 等待进行中的压缩完成
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.f.Sync()
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// appendSet 追加新增数据记录,成功后执行apply修改缓存
+func (l *AppendLog) appendSet(group, key string, value cachebackend.Valuer, apply func()) error {
+	return l.appendApply(group, key, encodeRecord(aofSet, group, key, value.Bytes(), value.Expire()), apply)
+}
+
+// appendDel 追加删除数据记录,成功后执行apply修改缓存
+func (l *AppendLog) appendDel(group, key string, apply func()) error {
+	return l.appendApply(group, key, encodeRecord(aofDel, group, key, nil, 0), apply)
+}
+
+// appendApply 在key的锁内追加记录并执行apply,追加失败时不执行apply
+func (l *AppendLog) appendApply(group, key string, record []byte, apply func()) error {
+	mu := l.keyLock(group, key)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := l.append(record); err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// keyLock 获取group与key所在分段的锁
+func (l *AppendLog) keyLock(group, key string) *sync.Mutex {
+	h := crc32.NewIEEE()
+	_, _ = h.Write([]byte(group))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(key))
+	return &l.keyLocks[h.Sum32()%aofKeyLocks]
+}
+
+func (l *AppendLog) append(record []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return fmt.Errorf("append log closed")
+	}
+	n, err := l.f.Write(record)
+	if err != nil {
+		// 截断写入了一部分的记录,避免之后的记录无法回放
+		if n > 0 {
+			_ = l.f.Truncate(l.segmentSize)
+		}
+		return err
+	}
+	if l.fsync == consts.FsyncAlways {
+		if err := l.f.Sync(); err != nil {
+			// 未写入磁盘的记录不保留,日志与缓存保持一致
+			_ = l.f.Truncate(l.segmentSize)
+			return err
+		}
+	} else {
+		l.dirty = true
+	}
+	l.segmentSize += int64(n)
+	l.logBytes += int64(n)
+	if l.segmentSize >= l.segmentBytes {
+		// 记录已写入当前日志段,切换失败时继续写入当前日志段,下次追加时重试
+		if err := l.roll(); err != nil {
+			log.Println("[Hit] roll append log:", err)
+		}
+	}
+	return nil
+}
+
+// run 每秒按fsync策略写入磁盘,并在日志超过compactBytes时压缩
+func (l *AppendLog) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if l.fsync == consts.FsyncEverySec {
+				if err := l.sync(); err != nil {
+					log.Println("[Hit] fsync append log:", err)
+				}
+			}
+			l.mu.Lock()
+			full := l.logBytes >= l.compactBytes
+			l.mu.Unlock()
+			if full {
+				if err := l.compact(); err != nil {
+					log.Println("[Hit] compact append log:", err)
+				}
+			}
+		}
+	}
+}
+
+// sync 将未fsync的数据写入磁盘
+func (l *AppendLog) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dirty || l.closed {
+		return nil
+	}
+	l.dirty = false
+	return l.f.Sync()
+}
+
+// compact 切换到新的日志段,将当前缓存中的数据写入一个kind为aofBase的日志段替换之前的日志段.
+// 切换时持有所有key锁,因此切换前追加的操作均已修改缓存,切换后的操作在新日志段中
+func (l *AppendLog) compact() error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	for i := range l.keyLocks {
+		l.keyLocks[i].Lock()
+	}
+	l.mu.Lock()
+	base := l.seq
+	err := l.roll()
+	l.logBytes = 0
+	l.mu.Unlock()
+	for i := range l.keyLocks {
+		l.keyLocks[i].Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp := l.segmentPath(base) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	bw := bufio.NewWriter(f)
+	_, err = bw.Write(segmentHeader(aofBase))
+	n := 0
+	l.groups.rangeValues(func(group, key string, value cachebackend.Valuer) bool {
+		if err == nil {
+			_, err = bw.Write(encodeRecord(aofSet, group, key, value.Bytes(), value.Expire()))
+			n++
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.segmentPath(base)); err != nil {
+		return err
+	}
+
+	seqs, err := l.segments()
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if seq < base {
+			_ = os.Remove(l.segmentPath(seq))
+		}
+	}
+	log.Printf("[Hit] compact append log: %d entries", n)
+	return nil
+}
+
+// roll fsync并关闭当前日志段,创建下一个日志段,调用方必须持有l.mu
+func (l *AppendLog) roll() error {
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	// 先创建新日志段,创建失败时继续使用当前日志段
+	old := l.f
+	if err := l.openSegment(l.seq + 1); err != nil {
+		return err
+	}
+	l.dirty = false
+	return old.Close()
+}
+
+// openSegment 创建日志段并写入头部,调用方必须持有l.mu
+func (l *AppendLog) openSegment(seq uint64) error {
+	f, err := os.OpenFile(l.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	header := segmentHeader(aofLog)
+	if _, err := f.Write(header); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	l.f = f
+	l.seq = seq
+	l.segmentSize = int64(len(header))
+	return nil
+}
+
+// replay 从最后一个kind为aofBase的日志段开始回放,返回回放的记录数.
+// 最后一个日志段末尾不完整的记录(写入时进程退出)会被截断
+func (l *AppendLog) replay() (int, error) {
+	seqs, err := l.segments()
+	if err != nil || len(seqs) == 0 {
+		return 0, err
+	}
+	l.seq = seqs[len(seqs)-1]
+
+	datas := make([][]byte, len(seqs))
+	start := 0
+	for i, seq := range seqs {
+		if datas[i], err = ioutil.ReadFile(l.segmentPath(seq)); err != nil {
+			return 0, err
+		}
+		if kind, _, err := readSegment(datas[i], nil); err == nil && kind == aofBase {
+			start = i
+		}
+	}
+
+	n, dropped := 0, 0
+	var dropErr error
+	defer func() {
+		if dropped > 0 {
+			log.Printf("[Hit] drop %d append log records: %v", dropped, dropErr)
+		}
+	}()
+	now := time.Now().Unix()
+	for i := start; i < len(seqs); i++ {
+		path := l.segmentPath(seqs[i])
+		kind, end, err := readSegment(datas[i], func(r aofRecord) {
+			if err := l.groups.apply(r, now); err != nil {
+				dropped++
+				dropErr = err
+				return
+			}
+			n++
+		})
+		if kind != aofBase {
+			l.logBytes += end
+		}
+		if err == nil {
+			continue
+		}
+		if i != len(seqs)-1 {
+			return n, fmt.Errorf("corrupted append log %s: %v", path, err)
+		}
+		log.Printf("[Hit] truncate append log %s at %d: %v", path, end, err)
+		if end == 0 {
+			// 头部不完整
+			err = os.Remove(path)
+		} else {
+			err = os.Truncate(path, end)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// segments 按序号升序返回所有日志段
+func (l *AppendLog) segments() ([]uint64, error) {
+	infos, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, aofExt) {
+			continue
+		}
+		if seq, err := strconv.ParseUint(strings.TrimSuffix(name, aofExt), 10, 64); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	return seqs, nil
+}
+
+func (l *AppendLog) segmentPath(seq uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016d%s", seq, aofExt))
+}
+
+// aofRecord 追加日志中的一条记录
+type aofRecord struct {
+	op         byte
+	group, key string
+	value      []byte
+	expire     int64
+}
+
+// apply 回放一条记录,不再追加到日志中.分组不存在且无法创建时返回错误
+func (gs *Groups) apply(r aofRecord, now int64) error {
+	switch r.op {
+	case aofSet:
+		g, err := gs.getOrCreateGroup(r.group)
+		if err != nil {
+			return err
+		}
+		if r.expire > 0 && r.expire <= now {
+			g.mainCache.Remove(r.key)
+		} else {
+			g.populateCache(r.key, lru.NewValue(r.value, r.expire, r.group))
+		}
+	case aofDel:
+		if g := gs.GetGroup(r.group); g != nil {
+			g.mainCache.Remove(r.key)
+		}
+	}
+	return nil
+}
+
+func segmentHeader(kind byte) []byte {
+	var buf bytes.Buffer
+	w := &snapshotWriter{w: &buf, crc: crc32.NewIEEE()}
+	w.writeString(aofMagic)
+	w.writeUvarint(aofVersion)
+	w.writeByte(kind)
+	return buf.Bytes()
+}
+
+func encodeRecord(op byte, group, key string, value []byte, expire int64) []byte {
+	var buf bytes.Buffer
+	w := &snapshotWriter{w: &buf, crc: crc32.NewIEEE()}
+	w.writeByte(op)
+	w.writeBytes([]byte(group))
+	w.writeBytes([]byte(key))
+	w.writeBytes(value)
+	w.writeVarint(expire)
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, w.crc.Sum32())
+	buf.Write(sum)
+	return buf.Bytes()
+}
+
+// readSegment 读取日志段,对每条完整的记录调用fn(可为nil),返回日志段类型以及最后一条完整记录的结束位置
+func readSegment(data []byte, fn func(r aofRecord)) (kind byte, end int64, err error) {
+	br := bytes.NewReader(data)
+	r := &snapshotReader{r: br, crc: crc32.NewIEEE()}
+	if magic := r.readFull(len(aofMagic)); r.err == nil && string(magic) != aofMagic {
+		return 0, 0, fmt.Errorf("not an append log")
+	}
+	if version := r.readUvarint(); r.err == nil && version != aofVersion {
+		return 0, 0, fmt.Errorf("unsupported append log version: %d", version)
+	}
+	kind = r.readByte()
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	end = int64(len(data) - br.Len())
+	if fn == nil {
+		return kind, end, nil
+	}
+
+	for br.Len() > 0 {
+		r.crc.Reset()
+		record := aofRecord{
+			op:     r.readByte(),
+			group:  string(r.readBytes()),
+			key:    string(r.readBytes()),
+			value:  r.readBytes(),
+			expire: r.readVarint(),
+		}
+		sum := r.crc.Sum32()
+		if expect := r.readFull(4); r.err == nil && binary.BigEndian.Uint32(expect) != sum {
+			r.err = fmt.Errorf("checksum mismatch")
+		}
+		if r.err == nil && record.op != aofSet && record.op != aofDel {
+			r.err = fmt.Errorf("unknown record type %d", record.op)
+		}
+		if r.err != nil {
+			return kind, end, r.err
+		}
+		fn(record)
+		end = int64(len(data) - br.Len())
+	}
+	return kind, end, nil
+}
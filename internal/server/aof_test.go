@@ -0,0 +1,266 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/chenquan/hit/internal/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openAppendLog 创建包含users分组的集合并开启追加日志
+func openAppendLog(t *testing.T, dir string) (*Groups, *AppendLog, int) {
+	groups := NewGroups()
+	groups.NewGroup("users", cache.NewSyncCacheDefault(0))
+	l, n, err := groups.OpenAppendLog(dir, consts.FsyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return groups, l, n
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "hit-aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func TestAppendLog(t *testing.T) {
+	dir := tempDir(t)
+	groups, l, _ := openAppendLog(t, dir)
+	g := groups.GetGroup("users")
+	_ = g.Add("tom", lru.NewValue([]byte("cat"), 0, "users"))
+	_ = g.Add("jerry", lru.NewValue([]byte("mouse"), 0, "users"))
+	_ = g.Add("tom", lru.NewValue([]byte("cat2"), 0, "users"))
+	_ = g.Delete("jerry")
+	_ = g.Add("expired", lru.NewValue([]byte("v"), time.Now().Add(-time.Second).Unix(), "users"))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭后不再追加
+	_ = g.Add("closed", lru.NewValue([]byte("v"), 0, "users"))
+
+	groups, l, n := openAppendLog(t, dir)
+	defer l.Close()
+	if n != 5 {
+		t.Fatalf("expected 5 records replayed but got %d", n)
+	}
+	g = groups.GetGroup("users")
+	if v, err := g.Get("tom"); err != nil || string(v.Bytes()) != "cat2" {
+		t.Fatalf("unexpected tom: %v %v", v, err)
+	}
+	for _, key := range []string{"jerry", "expired", "closed"} {
+		if _, err := g.Get(key); err == nil {
+			t.Fatalf("%s should not be restored", key)
+		}
+	}
+}
+
+func TestAppendLogTornTail(t *testing.T) {
+	dir := tempDir(t)
+	groups, l, _ := openAppendLog(t, dir)
+	_ = groups.GetGroup("users").Add("tom", lru.NewValue([]byte("cat"), 0, "users"))
+	path := l.segmentPath(l.seq)
+	_ = l.Close()
+
+	// 模拟写入记录时进程退出
+	record := encodeRecord(aofSet, "users", "jerry", []byte("mouse"), 0)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.Write(record[:len(record)-2])
+	_ = f.Close()
+	size := fileSize(t, path)
+
+	groups, l, n := openAppendLog(t, dir)
+	defer l.Close()
+	if n != 1 {
+		t.Fatalf("expected 1 record replayed but got %d", n)
+	}
+	if _, err := groups.GetGroup("users").Get("tom"); err != nil {
+		t.Fatal(err)
+	}
+	if fileSize(t, path) != size-int64(len(record)-2) {
+		t.Fatalf("expected torn record to be truncated")
+	}
+}
+
+func TestAppendLogCorrupted(t *testing.T) {
+	dir := tempDir(t)
+	groups, l, _ := openAppendLog(t, dir)
+	_ = groups.GetGroup("users").Add("tom", lru.NewValue([]byte("cat"), 0, "users"))
+	path := l.segmentPath(l.seq)
+	_ = l.Close()
+	_, l, _ = openAppendLog(t, dir)
+	_ = l.Close()
+
+	// 非最后一个日志段损坏
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	_ = ioutil.WriteFile(path, data, 0644)
+	if _, _, err := NewGroups().OpenAppendLog(dir, consts.FsyncAlways, 0); err == nil {
+		t.Fatal("expected error for corrupted segment")
+	}
+}
+
+func TestAppendLogCompact(t *testing.T) {
+	dir := tempDir(t)
+	groups, l, _ := openAppendLog(t, dir)
+	g := groups.GetGroup("users")
+	for i := 0; i < 100; i++ {
+		_ = g.Add("key"+strconv.Itoa(i%10), lru.NewValue([]byte(strconv.Itoa(i)), 0, "users"))
+	}
+	_ = g.Delete("key0")
+	if err := l.compact(); err != nil {
+		t.Fatal(err)
+	}
+	_ = g.Add("key1", lru.NewValue([]byte("new"), 0, "users"))
+	_ = l.Close()
+
+	seqs, _ := l.segments()
+	if len(seqs) != 2 {
+		t.Fatalf("expected base and new segment after compaction but got %v", seqs)
+	}
+	if kind, _, err := readSegment(mustRead(t, l.segmentPath(seqs[0])), nil); err != nil || kind != aofBase {
+		t.Fatalf("expected base segment: %v", err)
+	}
+
+	groups, l, n := openAppendLog(t, dir)
+	defer l.Close()
+	// 9条压缩后的数据与1条新数据
+	if n != 10 {
+		t.Fatalf("expected 10 records replayed but got %d", n)
+	}
+	g = groups.GetGroup("users")
+	if _, err := g.Get("key0"); err == nil {
+		t.Fatal("deleted key0 should not be restored")
+	}
+	if v, err := g.Get("key1"); err != nil || string(v.Bytes()) != "new" {
+		t.Fatalf("unexpected key1: %v %v", v, err)
+	}
+	if v, err := g.Get("key9"); err != nil || string(v.Bytes()) != "99" {
+		t.Fatalf("unexpected key9: %v %v", v, err)
+	}
+}
+
+func TestAppendLogConcurrent(t *testing.T) {
+	dir := tempDir(t)
+	groups, l, _ := openAppendLog(t, dir)
+	g := groups.GetGroup("users")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				_ = g.Delete("tom")
+				return
+			}
+			_ = g.Add("tom", lru.NewValue([]byte(strconv.Itoa(i)), 0, "users"))
+		}(i)
+	}
+	wg.Wait()
+	want, wantErr := g.Get("tom")
+	_ = l.Close()
+
+	// 回放后与缓存中的数据一致
+	groups, l, _ = openAppendLog(t, dir)
+	defer l.Close()
+	got, err := groups.GetGroup("users").Get("tom")
+	if (err == nil) != (wantErr == nil) || (err == nil && string(got.Bytes()) != string(want.Bytes())) {
+		t.Fatalf("expected %v %v after replay but got %v %v", want, wantErr, got, err)
+	}
+}
+
+func TestAppendLogFailed(t *testing.T) {
+	groups, l, _ := openAppendLog(t, tempDir(t))
+	g := groups.GetGroup("users")
+	_ = g.Add("tom", lru.NewValue([]byte("cat"), 0, "users"))
+	// 模拟写入失败
+	_ = l.f.Close()
+	if err := g.Add("tom", lru.NewValue([]byte("cat2"), 0, "users")); err == nil {
+		t.Fatal("expected error when append fails")
+	}
+	if err := g.Delete("tom"); err == nil {
+		t.Fatal("expected error when append fails")
+	}
+	if v, err := g.Get("tom"); err != nil || string(v.Bytes()) != "cat" {
+		t.Fatalf("cache should not be modified when append fails: %v %v", v, err)
+	}
+}
+
+func TestAppendLogRollFailed(t *testing.T) {
+	dir := tempDir(t)
+	groups, l, _ := openAppendLog(t, dir)
+	g := groups.GetGroup("users")
+	// 下一个日志段已存在,切换日志段失败
+	l.segmentBytes = 1
+	if err := ioutil.WriteFile(l.segmentPath(l.seq+1), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Add("tom", lru.NewValue([]byte("cat"), 0, "users")); err != nil {
+		t.Fatalf("record was written, add should succeed: %v", err)
+	}
+	if v, err := g.Get("tom"); err != nil || string(v.Bytes()) != "cat" {
+		t.Fatalf("cache should be modified after the record is written: %v %v", v, err)
+	}
+	if err := g.Add("jerry", lru.NewValue([]byte("mouse"), 0, "users")); err != nil {
+		t.Fatalf("append should continue in the current segment: %v", err)
+	}
+}
+
+func TestAppendLogDropped(t *testing.T) {
+	dir := tempDir(t)
+	groups, l, _ := openAppendLog(t, dir)
+	_ = groups.GetGroup("users").Add("tom", lru.NewValue([]byte("cat"), 0, "users"))
+	_ = l.Close()
+
+	// 分组未配置且不自动创建时丢弃记录
+	groups = NewGroups()
+	groups.SetAutoCreate(false, 0)
+	l, n, err := groups.OpenAppendLog(dir, consts.FsyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if n != 0 {
+		t.Fatalf("expected no records replayed but got %d", n)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
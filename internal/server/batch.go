@@ -0,0 +1,127 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+)
+
+// serveBatch 处理批量操作 POST /<basepath>/<groupname>?batch=<op>
+func (p *HTTPPool) serveBatch(op, groupName string, w http.ResponseWriter, r *http.Request) {
+	if groupName == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var in, out proto.Message
+	switch op {
+	case consts.BatchGet:
+		in = &pb.MultiGetRequest{}
+	case consts.BatchSet:
+		in = &pb.MultiSetRequest{}
+	case consts.BatchDel:
+		in = &pb.MultiDelRequest{}
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := proto.Unmarshal(body, in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	switch in := in.(type) {
+	case *pb.MultiGetRequest:
+		out = p.groups.multiGet(groupName, in)
+	case *pb.MultiSetRequest:
+		out = p.groups.multiSet(groupName, in)
+	case *pb.MultiDelRequest:
+		out = p.groups.multiDel(groupName, in)
+	}
+
+	writeResponse(w, out)
+}
+
+// multiGet 批量获取,单个key的失败记录在对应的结果中
+func (gs *Groups) multiGet(groupName string, in *pb.MultiGetRequest) *pb.MultiGetResponse {
+	group, err := gs.getOrCreateGroup(groupName)
+	if err != nil {
+		return &pb.MultiGetResponse{Success: false, Message: err.Error()}
+	}
+	results := make([]*pb.Result, 0, len(in.Keys))
+	for _, key := range in.Keys {
+		valuer, err := group.Get(key)
+		if err != nil {
+			results = append(results, &pb.Result{Key: key, Success: false, Message: err.Error()})
+			continue
+		}
+		data := &pb.Data{Group: groupName, Value: valuer.Bytes(), Expire: valuer.Expire()}
+		results = append(results, &pb.Result{Key: key, Success: true, Message: "success", Data: data})
+	}
+	return &pb.MultiGetResponse{Success: true, Message: "success", Results: results}
+}
+
+// multiSet 批量新增
+func (gs *Groups) multiSet(groupName string, in *pb.MultiSetRequest) *pb.MultiSetResponse {
+	group, err := gs.getOrCreateGroup(groupName)
+	if err != nil {
+		return &pb.MultiSetResponse{Success: false, Message: err.Error()}
+	}
+	results := make([]*pb.Result, 0, len(in.Items))
+	for _, item := range in.Items {
+		expire := group.ExpireAt(item.Ttl)
+		if err := group.Add(item.Key, lru.NewValue(item.Value, expire, groupName)); err != nil {
+			results = append(results, &pb.Result{Key: item.Key, Success: false, Message: err.Error()})
+			continue
+		}
+		data := &pb.Data{Group: groupName, Value: item.Value, Expire: expire}
+		results = append(results, &pb.Result{Key: item.Key, Success: true, Message: "success", Data: data})
+	}
+	return &pb.MultiSetResponse{Success: true, Message: "success", Results: results}
+}
+
+// multiDel 批量删除
+func (gs *Groups) multiDel(groupName string, in *pb.MultiDelRequest) *pb.MultiDelResponse {
+	group := gs.GetGroup(groupName)
+	results := make([]*pb.Result, 0, len(in.Keys))
+	for _, key := range in.Keys {
+		// 分组不存在时其中也不存在该key,视为删除成功
+		if group == nil {
+			results = append(results, &pb.Result{Key: key, Success: true, Message: "success"})
+			continue
+		}
+		if err := group.Delete(key); err != nil {
+			results = append(results, &pb.Result{Key: key, Success: false, Message: err.Error()})
+			continue
+		}
+		results = append(results, &pb.Result{Key: key, Success: true, Message: "success"})
+	}
+	return &pb.MultiDelResponse{Success: true, Message: "success", Results: results}
+}
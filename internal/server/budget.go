@@ -0,0 +1,137 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"sync/atomic"
+)
+
+// 节点内存预算: 所有分组共享同一个内存上限,超出时从"占用内存/权重"最大的分组中淘汰数据,
+// 使各分组在内存紧张时按权重分配内存.
+// 写入时只累加占用内存的估计值(不小于实际值),估计值超出上限时才统计各分组的实际占用并淘汰数据.
+
+// SetMemoryLimit 设置默认集合所有分组共享的内存上限,0表示不限制
+func SetMemoryLimit(bytes int64) {
+	defaultGroups.SetMemoryLimit(bytes)
+}
+
+// SetMaxGroups 设置默认集合的分组数上限,达到上限后不再自动创建分组,0表示不限制
+func SetMaxGroups(n int) {
+	defaultGroups.SetMaxGroups(n)
+}
+
+// SetMemoryLimit 设置集合所有分组共享的内存上限,0表示不限制
+func (gs *Groups) SetMemoryLimit(bytes int64) {
+	atomic.StoreInt64(&gs.memoryLimit, bytes)
+	if bytes <= 0 {
+		return
+	}
+	gs.budgetMu.Lock()
+	defer gs.budgetMu.Unlock()
+	gs.enforceMemoryLimit(bytes)
+}
+
+// SetMaxGroups 设置集合的分组数上限,达到上限后不再自动创建分组,0表示不限制
+func (gs *Groups) SetMaxGroups(n int) {
+	atomic.StoreInt64(&gs.maxGroups, int64(n))
+}
+
+// WithWeight 设置分组在节点内存预算中的权重,默认为1
+func WithWeight(weight int) Option {
+	return func(g *Group) {
+		if weight > 0 {
+			g.weight = int64(weight)
+		}
+	}
+}
+
+// groupsFull 分组数是否已达上限,调用方必须持有gs.mu
+func (gs *Groups) groupsFull() bool {
+	limit := atomic.LoadInt64(&gs.maxGroups)
+	return limit > 0 && int64(len(gs.groups)) >= limit
+}
+
+// addBytes 记录分组新增的数据,节点内存超出上限时按权重从所属分组集合的各分组淘汰数据
+func (g *Group) addBytes(key string, value cachebackend.Valuer) {
+	if g.owner != nil {
+		g.owner.addBytes(int64(len(key) + value.Len()))
+	}
+}
+
+// addBytes 累加占用内存的估计值,超出上限时淘汰数据
+func (gs *Groups) addBytes(n int64) {
+	limit := atomic.LoadInt64(&gs.memoryLimit)
+	if limit <= 0 || atomic.AddInt64(&gs.usedBytes, n) <= limit {
+		return
+	}
+	gs.budgetMu.Lock()
+	defer gs.budgetMu.Unlock()
+	// 等待锁期间其他协程可能已完成淘汰
+	if atomic.LoadInt64(&gs.usedBytes) > limit {
+		gs.enforceMemoryLimit(limit)
+	}
+}
+
+// enforceMemoryLimit 统计各分组的实际占用,超出上限时按权重从各分组淘汰数据,并以实际占用修正估计值.
+// 调用方必须持有gs.budgetMu
+func (gs *Groups) enforceMemoryLimit(limit int64) {
+	estimated := atomic.LoadInt64(&gs.usedBytes)
+
+	gs.mu.RLock()
+	usage := make(map[*Group]int64, len(gs.groups))
+	var total int64
+	for _, g := range gs.groups {
+		bytes := g.Stats().Bytes
+		usage[g] = bytes
+		total += bytes
+	}
+	gs.mu.RUnlock()
+
+	for total > limit {
+		g := heaviest(usage)
+		if g == nil {
+			break
+		}
+		evicter, ok := g.mainCache.(cachebackend.Evicter)
+		if !ok || !evicter.Evict() {
+			// 无法继续淘汰的分组不再参与
+			delete(usage, g)
+			continue
+		}
+		bytes := g.Stats().Bytes
+		total -= usage[g] - bytes
+		usage[g] = bytes
+	}
+	// 统计期间新增的数据可能被重复计入,估计值仍不小于实际值
+	atomic.AddInt64(&gs.usedBytes, total-estimated)
+}
+
+// heaviest 占用内存与权重之比最大的分组
+func heaviest(usage map[*Group]int64) *Group {
+	var target *Group
+	for g, bytes := range usage {
+		if bytes <= 0 {
+			continue
+		}
+		// bytes/weight > usage[target]/target.weight
+		if target == nil || bytes*target.weight > usage[target]*g.weight {
+			target = g
+		}
+	}
+	return target
+}
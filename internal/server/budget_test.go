@@ -0,0 +1,84 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/chenquan/hit/internal/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"strconv"
+	"testing"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	SetMemoryLimit(4000)
+	defer SetMemoryLimit(0)
+
+	light := NewGroupDefault("budget-light", 0)
+	heavy := NewGroupDefault("budget-heavy", 0, WithWeight(3))
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		_ = light.Add(key, lru.NewValue([]byte("0123456789"), 0, "budget-light"))
+		_ = heavy.Add(key, lru.NewValue([]byte("0123456789"), 0, "budget-heavy"))
+	}
+
+	lightBytes, heavyBytes := light.Stats().Bytes, heavy.Stats().Bytes
+	if total := NodeStats().Bytes; total > 4000 {
+		t.Fatalf("node memory %d exceeds limit", total)
+	}
+	// 按权重1:3分配内存
+	if heavyBytes < lightBytes*2 || heavyBytes > lightBytes*4 {
+		t.Fatalf("unexpected weighted usage light=%d heavy=%d", lightBytes, heavyBytes)
+	}
+	if light.Stats().Evictions == 0 || heavy.Stats().Evictions == 0 {
+		t.Fatalf("expected evictions in both groups")
+	}
+}
+
+func TestMaxGroups(t *testing.T) {
+	n := len(Stats())
+	SetMaxGroups(n + 1)
+	defer SetMaxGroups(0)
+
+	if _, err := getOrCreateGroup("max-groups-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getOrCreateGroup("max-groups-2"); err == nil {
+		t.Fatalf("expected too many groups error")
+	}
+	if _, err := getOrCreateGroup("max-groups-1"); err != nil {
+		t.Fatalf("existing group should be returned: %v", err)
+	}
+}
+
+func TestMemoryLimitGroups(t *testing.T) {
+	// 内存上限属于各自的分组集合
+	groups := NewGroups()
+	groups.SetMemoryLimit(1000)
+	g := groups.NewGroup("users", cache.NewSyncCacheDefault(0))
+	other := NewGroupDefault("budget-other", 0)
+	for i := 0; i < 200; i++ {
+		key := "key" + strconv.Itoa(i)
+		_ = g.Add(key, lru.NewValue([]byte("0123456789"), 0, "users"))
+		_ = other.Add(key, lru.NewValue([]byte("0123456789"), 0, "budget-other"))
+	}
+	if bytes := g.Stats().Bytes; bytes > 1000 {
+		t.Fatalf("group memory %d exceeds limit", bytes)
+	}
+	if other.Stats().Evictions != 0 {
+		t.Fatalf("default groups should not be limited")
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"fmt"
+	"github.com/chenquan/hit/internal/cache"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/consts"
+	"sync"
+	"time"
+)
+
+// Groups 节点的分组集合.包级函数(NewGroup,GetGroup等)使用默认集合,
+// 在同一进程中运行多个节点时可为每个节点创建独立的集合
+type Groups struct {
+	// 原子操作的字段放在最前面以保证64位对齐
+	memoryLimit int64 // 所有分组共享的内存上限(字节),0表示不限制
+	maxGroups   int64 // 分组数上限,0表示不限制
+	usedBytes   int64 // 所有分组占用内存的估计值,不小于实际值
+
+	mu     sync.RWMutex
+	groups map[string]*Group
+
+	autoCreate      bool  // 是否自动创建未知分组
+	autoCreateBytes int64 // 自动创建分组的缓存大小
+
+	janitorInterval time.Duration // 分组后台清理过期数据的间隔,小于等于0时不清理
+
+	budgetMu sync.Mutex // 串行化淘汰过程
+
+	appendLog *AppendLog // 追加日志,未开启时为nil
+}
+
+var defaultGroups = NewGroups()
+
+// NewGroups 创建分组集合,默认自动创建未知分组
+func NewGroups() *Groups {
+	return &Groups{
+		groups:          make(map[string]*Group),
+		autoCreate:      true,
+		autoCreateBytes: consts.DefaultGroupCacheBytes,
+		janitorInterval: consts.DefaultJanitorInterval,
+	}
+}
+
+// SetAutoCreate 设置请求未知分组时是否自动创建,以及自动创建分组的缓存大小
+func SetAutoCreate(enable bool, cacheBytes int64) {
+	defaultGroups.SetAutoCreate(enable, cacheBytes)
+}
+
+// SetAutoCreate 设置请求未知分组时是否自动创建,以及自动创建分组的缓存大小
+func (gs *Groups) SetAutoCreate(enable bool, cacheBytes int64) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.autoCreate = enable
+	gs.autoCreateBytes = cacheBytes
+}
+
+// SetJanitorInterval 设置之后创建的分组清理过期数据的间隔,小于等于0时不启动后台清理
+func SetJanitorInterval(interval time.Duration) {
+	defaultGroups.SetJanitorInterval(interval)
+}
+
+// SetJanitorInterval 设置之后创建的分组清理过期数据的间隔,小于等于0时不启动后台清理
+func (gs *Groups) SetJanitorInterval(interval time.Duration) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.janitorInterval = interval
+}
+
+// StopJanitors 停止默认集合中所有分组的后台清理
+func StopJanitors() {
+	defaultGroups.StopJanitors()
+}
+
+// StopJanitors 停止集合中所有分组的后台清理,用于停止节点
+func (gs *Groups) StopJanitors() {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	for _, g := range gs.groups {
+		g.stopJanitor()
+	}
+}
+
+// NewGroup 在集合中创建分组,mainCache必须是并发安全的.
+// mainCache实现了cache.Janitor时按集合的间隔启动后台清理,替换同名分组时停止原分组的后台清理
+func (gs *Groups) NewGroup(name string, mainCache cachebackend.Cache, opts ...Option) *Group {
+	g := newGroup(name, mainCache, opts...)
+	g.owner = gs
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.addGroup(g)
+	return g
+}
+
+// addGroup 将分组加入集合并启动后台清理,调用方必须持有gs.mu
+func (gs *Groups) addGroup(g *Group) {
+	if old, ok := gs.groups[g.name]; ok {
+		old.stopJanitor()
+	}
+	g.startJanitor(gs.janitorInterval)
+	gs.groups[g.name] = g
+}
+
+// GetGroup 获取分组,不存在时返回nil
+func (gs *Groups) GetGroup(name string) *Group {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.groups[name]
+}
+
+// getOrCreateGroup 获取默认集合中的分组,不存在时按配置自动创建
+func getOrCreateGroup(name string) (*Group, error) {
+	return defaultGroups.getOrCreateGroup(name)
+}
+
+// getOrCreateGroup 获取分组,不存在时按配置自动创建
+func (gs *Groups) getOrCreateGroup(name string) (*Group, error) {
+	if group := gs.GetGroup(name); group != nil {
+		return group, nil
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if group, ok := gs.groups[name]; ok {
+		return group, nil
+	}
+	if !gs.autoCreate {
+		return nil, fmt.Errorf("group %s not found", name)
+	}
+	if gs.groupsFull() {
+		return nil, fmt.Errorf("too many groups, can not create group %s", name)
+	}
+	g := newGroup(name, cache.NewSyncCacheDefault(gs.autoCreateBytes))
+	g.owner = gs
+	gs.addGroup(g)
+	return g, nil
+}
+
+// Stats 集合中各分组的统计
+func (gs *Groups) Stats() map[string]cachebackend.Stats {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	stats := make(map[string]cachebackend.Stats, len(gs.groups))
+	for name, g := range gs.groups {
+		stats[name] = g.Stats()
+	}
+	return stats
+}
+
+// NodeStats 集合中所有分组的统计之和
+func (gs *Groups) NodeStats() cachebackend.Stats {
+	var total cachebackend.Stats
+	for _, stats := range gs.Stats() {
+		total = total.Add(stats)
+	}
+	return total
+}
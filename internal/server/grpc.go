@@ -0,0 +1,61 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	pb "github.com/chenquan/hit/internal/remotecache"
+)
+
+// GroupCacheServer 实现gRPC GroupCache服务,与HTTPPool共用分组
+type GroupCacheServer struct {
+	groups *Groups
+}
+
+// NewGroupCacheServer 创建gRPC GroupCache服务
+func NewGroupCacheServer() *GroupCacheServer {
+	return NewGroupCacheServerWithGroups(defaultGroups)
+}
+
+// NewGroupCacheServerWithGroups 使用指定的分组集合创建gRPC GroupCache服务
+func NewGroupCacheServerWithGroups(groups *Groups) *GroupCacheServer {
+	return &GroupCacheServer{groups: groups}
+}
+
+func (s *GroupCacheServer) Get(ctx context.Context, in *pb.GetRequest) (*pb.GetResponse, error) {
+	return s.groups.getValue(in.Group, in.Key), nil
+}
+
+func (s *GroupCacheServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	return s.groups.setValue(in.Group, in.Key, in), nil
+}
+
+func (s *GroupCacheServer) Del(ctx context.Context, in *pb.DelRequest) (*pb.DelResponse, error) {
+	return s.groups.delValue(in.Group, in.Key), nil
+}
+
+func (s *GroupCacheServer) MultiGet(ctx context.Context, in *pb.MultiGetRequest) (*pb.MultiGetResponse, error) {
+	return s.groups.multiGet(in.Group, in), nil
+}
+
+func (s *GroupCacheServer) MultiSet(ctx context.Context, in *pb.MultiSetRequest) (*pb.MultiSetResponse, error) {
+	return s.groups.multiSet(in.Group, in), nil
+}
+
+func (s *GroupCacheServer) MultiDel(ctx context.Context, in *pb.MultiDelRequest) (*pb.MultiDelResponse, error) {
+	return s.groups.multiDel(in.Group, in), nil
+}
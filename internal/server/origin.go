@@ -0,0 +1,120 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"fmt"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"github.com/chenquan/hit/internal/consts"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Getter 缓存未命中时从源站加载key的数据
+type Getter interface {
+	Get(key string) ([]byte, error)
+}
+
+// GetterFunc 通过函数实现Getter
+type GetterFunc func(key string) ([]byte, error)
+
+func (f GetterFunc) Get(key string) ([]byte, error) {
+	return f(key)
+}
+
+// WithGetter 设置源站,未命中的key由当前节点从源站加载并写入缓存,
+// 同一key的并发加载只执行一次
+func WithGetter(getter Getter) Option {
+	return func(g *Group) {
+		g.getter = getter
+	}
+}
+
+// HTTPGetter 通过HTTP GET从源站加载数据
+type HTTPGetter struct {
+	group    string
+	template string // 源站URL模板,{group}与{key}分别替换为分组名与key
+	client   *http.Client
+	maxBytes int64 // 响应体的最大大小,超过时加载失败
+}
+
+// NewHTTPGetter 创建HTTP源站,源站返回200时响应体即为数据,返回404时视为数据不存在.
+// 响应体超过consts.DefaultOriginMaxBytes时加载失败
+func NewHTTPGetter(group, template string, timeout time.Duration) *HTTPGetter {
+	return &HTTPGetter{
+		group:    group,
+		template: template,
+		client:   &http.Client{Timeout: timeout},
+		maxBytes: consts.DefaultOriginMaxBytes,
+	}
+}
+
+func (h *HTTPGetter) Get(key string) ([]byte, error) {
+	u := strings.NewReplacer("{group}", url.PathEscape(h.group), "{key}", url.PathEscape(key)).Replace(h.template)
+	res, err := h.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		// 多读一个字节以判断响应体是否超过限制
+		data, err := ioutil.ReadAll(io.LimitReader(res.Body, h.maxBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > h.maxBytes {
+			return nil, fmt.Errorf("origin response exceeds %d bytes", h.maxBytes)
+		}
+		return data, nil
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("not found")
+	default:
+		return nil, fmt.Errorf("origin returned: %v", res.Status)
+	}
+}
+
+// load 从源站加载数据并写入缓存
+func (g *Group) load(key string) (cachebackend.Valuer, error) {
+	v, err := g.loader.Do(key, func() (interface{}, error) {
+		// 等待期间其他协程可能已写入缓存
+		if v, ok := g.mainCache.Get(key); ok {
+			if expire := v.Expire(); expire == 0 || expire > time.Now().Unix() {
+				return v, nil
+			}
+		}
+		bytes, err := g.getter.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("[Hit] load", key)
+		value := lru.NewValue(bytes, g.ExpireAt(0), g.name)
+		// 从源站加载的数据不写入追加日志,重启后按需重新从源站加载
+		g.populateCache(key, value)
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(cachebackend.Valuer), nil
+}
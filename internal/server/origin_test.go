@@ -0,0 +1,99 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/chenquan/hit/internal/cache"
+	"github.com/chenquan/hit/internal/consts"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPGetter(t *testing.T) {
+	var loads int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&loads, 1)
+		if r.URL.Path != "/users/tom" {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer origin.Close()
+
+	groups := NewGroups()
+	getter := NewHTTPGetter("users", origin.URL+"/{group}/{key}", time.Second)
+	groups.NewGroup("users", cache.NewSyncCacheDefault(0), WithGetter(getter))
+	s := httptest.NewServer(NewHTTPPoolWithGroups(groups))
+	defer s.Close()
+
+	// 并发请求同一个未命中的key,只访问一次源站
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := get(t, s.URL+consts.DefaultBasePath+"/users/tom")
+			if !out.Success || string(out.Data.Value) != "data" {
+				t.Errorf("expected value from origin but got %v", out)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expected 1 origin load but got %d", n)
+	}
+
+	// 已写入缓存
+	if _, err := groups.GetGroup("users").Get("tom"); err != nil || atomic.LoadInt32(&loads) != 1 {
+		t.Fatalf("expected cached value: %v", err)
+	}
+
+	// 源站不存在的key
+	if out := get(t, s.URL+consts.DefaultBasePath+"/users/jerry"); out.Success {
+		t.Fatalf("expected miss for unknown key")
+	}
+
+	// 响应体超过限制
+	getter.maxBytes = 3
+	if _, err := getter.Get("tom"); err == nil {
+		t.Fatalf("expected error for oversized origin response")
+	}
+}
+
+func get(t *testing.T, url string) *pb.GetResponse {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Error(err)
+		return &pb.GetResponse{}
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(res.Body)
+	out := &pb.GetResponse{}
+	if err := proto.Unmarshal(data, out); err != nil {
+		t.Error(err)
+	}
+	return out
+}
@@ -24,46 +24,86 @@ import (
 	_ "github.com/chenquan/hit/internal/consistenthash"
 	"github.com/chenquan/hit/internal/consts"
 	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/chenquan/hit/internal/utils"
 	"github.com/golang/protobuf/proto"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 )
 
 type Group struct {
-	name      string
-	mainCache *cache.SyncCache
+	owner      *Groups // 分组所属的节点分组集合
+	name       string
+	mainCache  cachebackend.Cache
+	defaultTTL time.Duration // 未指定过期时长时使用的时长
+	maxTTL     time.Duration // 最大过期时长,0表示不限制
+	weight     int64         // 节点内存预算中的权重
+	getter     Getter        // 源站,为nil时未命中直接返回
+	loader     *utils.Loader
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// Option 分组配置项
+type Option func(g *Group)
+
+// WithTTL 设置默认过期时长与最大过期时长,maxTTL为0表示不限制
+func WithTTL(defaultTTL, maxTTL time.Duration) Option {
+	return func(g *Group) {
+		g.defaultTTL = defaultTTL
+		g.maxTTL = maxTTL
+	}
+}
+
+func NewGroupDefault(name string, cacheBytes int64, opts ...Option) *Group {
+	return NewGroup(name, cache.NewSyncCacheDefault(cacheBytes), opts...)
+}
 
-func NewGroupDefault(name string, cacheBytes int64) *Group {
-	return NewGroup(name, cache.NewSyncCacheDefault(cacheBytes))
+// NewGroupWithPolicy 创建使用指定淘汰算法的分组,policy见consts.Policy*
+func NewGroupWithPolicy(name, policy string, cacheBytes int64, opts ...Option) (*Group, error) {
+	mainCache, err := cache.NewSyncCacheWithPolicy(policy, cacheBytes)
+	if err != nil {
+		return nil, err
+	}
+	return NewGroup(name, mainCache, opts...), nil
 }
 
-func NewGroup(name string, mainCache *cache.SyncCache) *Group {
+// NewGroupSharded 创建使用分片缓存的分组,shards为分片数
+func NewGroupSharded(name string, cacheBytes int64, shards int, opts ...Option) *Group {
+	return NewGroup(name, cache.NewShardedSyncCacheDefault(cacheBytes, shards), opts...)
+}
+
+// NewGroup mainCache必须是并发安全的,例如cache.SyncCache或cache.ShardedSyncCache
+func NewGroup(name string, mainCache cachebackend.Cache, opts ...Option) *Group {
+	return defaultGroups.NewGroup(name, mainCache, opts...)
+}
 
-	mu.Lock()
-	defer mu.Unlock()
+func newGroup(name string, mainCache cachebackend.Cache, opts ...Option) *Group {
 	g := &Group{
-		name:      name,
-		mainCache: mainCache,
+		name:       name,
+		mainCache:  mainCache,
+		defaultTTL: consts.DefaultNodeCacheDuration,
+		weight:     1,
+		loader:     &utils.Loader{},
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
-	groups[name] = g
 	return g
 }
 
 func GetGroup(name string) *Group {
-	mu.RLock()
-	defer mu.RUnlock()
-	return groups[name]
+	return defaultGroups.GetGroup(name)
+}
 
+// Stats 当前节点各分组的统计
+func Stats() map[string]cachebackend.Stats {
+	return defaultGroups.Stats()
+}
+
+// NodeStats 当前节点所有分组的统计之和
+func NodeStats() cachebackend.Stats {
+	return defaultGroups.NodeStats()
 }
 
 // Get 通过key获取value
@@ -73,44 +113,123 @@ func (g *Group) Get(key string) (cachebackend.Valuer, error) {
 	}
 	// 从本地缓存(一级缓存)中获取数据
 	if v, ok := g.mainCache.Get(key); ok {
-		// 检查数据是否过期
-		if v.Expire() <= time.Now().Unix() {
+		// 检查数据是否过期,到期时间为0表示永不过期
+		if expire := v.Expire(); expire > 0 && expire <= time.Now().Unix() {
 			// 过期删除
-			g.mainCache.Remove(key)
+			g.expire(key)
 		} else {
 			log.Println("[Hit] hit", key)
 			return v, nil
 		}
 	}
+	// 从源站加载数据
+	if g.getter != nil {
+		return g.load(key)
+	}
 	return nil, fmt.Errorf("not found")
 }
 func (g *Group) Add(key string, value cachebackend.Valuer) error {
 	if key == "" {
 		return fmt.Errorf("key is required")
 	}
-	g.mainCache.Add(key, value)
+	add := func() {
+		g.mainCache.Add(key, value)
+	}
+	// 开启追加日志时先追加日志再修改缓存,追加失败时不修改缓存
+	if l := g.appendLog(); l != nil {
+		if err := l.appendSet(g.name, key, value, add); err != nil {
+			return err
+		}
+	} else {
+		add()
+	}
+	g.addBytes(key, value)
 	return nil
 }
 func (g *Group) Delete(key string) error {
 	if key == "" {
 		return fmt.Errorf("key is required")
 	}
-	g.mainCache.Remove(key)
+	remove := func() {
+		g.mainCache.Remove(key)
+	}
+	if l := g.appendLog(); l != nil {
+		return l.appendDel(g.name, key, remove)
+	}
+	remove()
 	return nil
 }
 
+// ExpireAt 根据请求的过期时长(秒)计算到期时间戳,返回0表示永不过期.
+// ttl为0时使用默认时长,小于0时永不过期,均受最大过期时长限制.
+func (g *Group) ExpireAt(ttl int64) int64 {
+	d := time.Duration(ttl) * time.Second
+	switch {
+	case ttl == 0:
+		d = g.defaultTTL
+	case ttl < 0:
+		d = 0
+	}
+	if g.maxTTL > 0 && (d <= 0 || d > g.maxTTL) {
+		d = g.maxTTL
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Now().Add(d).Unix()
+}
+
 // populateCache 填充数据到缓存中
 func (g *Group) populateCache(key string, value cachebackend.Valuer) {
 	g.mainCache.Add(key, value)
+	g.addBytes(key, value)
+}
+
+// startJanitor 缓存支持时每隔interval清理一次过期数据,interval小于等于0时不清理
+func (g *Group) startJanitor(interval time.Duration) {
+	if janitor, ok := g.mainCache.(cache.Janitor); ok && interval > 0 {
+		janitor.StartJanitor(interval)
+	}
+}
+
+// stopJanitor 停止清理过期数据
+func (g *Group) stopJanitor() {
+	if janitor, ok := g.mainCache.(cache.Janitor); ok {
+		janitor.StopJanitor()
+	}
+}
+
+// expire 删除过期数据
+func (g *Group) expire(key string) {
+	if expirer, ok := g.mainCache.(cache.KeyExpirer); ok {
+		expirer.Expire(key)
+	} else {
+		g.mainCache.Remove(key)
+	}
+}
+
+// Stats 分组缓存统计
+func (g *Group) Stats() cachebackend.Stats {
+	if stater, ok := g.mainCache.(cachebackend.Stater); ok {
+		return stater.Stats()
+	}
+	return cachebackend.Stats{Items: int64(g.mainCache.Len())}
 }
 
 type HTTPPool struct {
 	basePath string
+	groups   *Groups
 }
 
 func NewHTTPPool() *HTTPPool {
+	return NewHTTPPoolWithGroups(defaultGroups)
+}
+
+// NewHTTPPoolWithGroups 使用指定的分组集合处理请求,例如在同一进程中运行多个节点
+func NewHTTPPoolWithGroups(groups *Groups) *HTTPPool {
 	return &HTTPPool{
 		basePath: consts.DefaultBasePath,
+		groups:   groups,
 	}
 }
 
@@ -123,9 +242,14 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
-	// /<basepath>/<groupname>/<key> required
-	s := r.URL.Path[len(p.basePath)+1:]
+	s := strings.TrimPrefix(r.URL.Path[len(p.basePath):], "/")
 	parts := strings.SplitN(s, "/", 2)
+	// /<basepath>/<groupname>?batch=<op> 批量操作,路径中带key时不视为批量操作
+	if op := r.URL.Query().Get(consts.BatchQuery); op != "" && len(parts) == 1 {
+		p.serveBatch(op, parts[0], w, r)
+		return
+	}
+	// /<basepath>/<groupname>/<key> required
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -136,87 +260,84 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		get(groupName, key, w, r)
+		p.get(groupName, key, w, r)
 	case http.MethodPost:
-		set(groupName, key, w, r)
+		p.set(groupName, key, w, r)
 	case http.MethodDelete:
-		del(groupName, key, w, r)
+		p.del(groupName, key, w, r)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
-func get(groupName string, key string, w http.ResponseWriter, r *http.Request) {
-	group := GetGroup(groupName)
-	if group == nil {
-		group = NewGroupDefault(groupName, 1000)
+func (p *HTTPPool) get(groupName string, key string, w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, p.groups.getValue(groupName, key))
+}
 
-	}
-	valuer, err := group.Get(key)
+func (p *HTTPPool) set(groupName string, key string, w http.ResponseWriter, r *http.Request) {
+	bytesData, err := ioutil.ReadAll(r.Body)
+	requestBody := &pb.SetRequest{}
 	if err == nil {
-		data := &pb.Data{
-			Group:  groupName,
-			Value:  valuer.Bytes(),
-			Expire: valuer.Expire(),
-		}
-		bytes, _ := proto.Marshal(&pb.GetResponse{Success: true, Message: "success", Data: data})
-		w.Header().Set("Content-Type", "application/octet-stream")
-		_, _ = w.Write(bytes)
+		err = proto.Unmarshal(bytesData, requestBody)
+	}
+	if err != nil {
+		writeResponse(w, &pb.SetResponse{Success: false, Message: "fail"})
 		return
 	}
-
-	bytes, _ := proto.Marshal(&pb.GetResponse{Success: false, Message: "fail"})
-	w.Header().Set("Content-Type", "application/octet-stream")
-	_, _ = w.Write(bytes)
-
+	writeResponse(w, p.groups.setValue(groupName, key, requestBody))
 }
-func set(groupName string, key string, w http.ResponseWriter, r *http.Request) {
-	bytesData, err := ioutil.ReadAll(r.Body)
-	requestBody := &pb.SetRequest{}
-	err = proto.Unmarshal(bytesData, requestBody)
-	if err == nil {
-		group := GetGroup(groupName)
-		if group == nil {
-			group = NewGroupDefault(groupName, 1000)
 
-		}
-		value := requestBody.Value
-		expire := time.Now().Add(consts.DefaultNodeCacheDuration).Unix()
-		err := group.Add(key, lru.NewValue(value, expire, groupName))
-
-		if err == nil {
-			data := &pb.Data{
-				Group:  groupName,
-				Value:  value,
-				Expire: expire,
-			}
-			bytes, _ := proto.Marshal(&pb.SetResponse{Success: true, Message: "success", Data: data})
-			w.Header().Set("Content-Type", "application/octet-stream")
-			_, _ = w.Write(bytes)
-			return
-		}
+func (p *HTTPPool) del(groupName string, key string, w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, p.groups.delValue(groupName, key))
+}
 
-	}
-	bytes, _ := proto.Marshal(&pb.GetResponse{Success: false, Message: "fail"})
-	w.Header().Set("Content-Type", "application/octet-stream")
+// writeResponse 以protobuf格式写入响应
+func writeResponse(w http.ResponseWriter, out proto.Message) {
+	bytes, _ := proto.Marshal(out)
+	w.Header().Set("Content-Type", consts.ContentType)
 	_, _ = w.Write(bytes)
-
 }
-func del(groupName string, key string, w http.ResponseWriter, r *http.Request) {
-	message := "fail"
-	success := false
-	group := GetGroup(groupName)
-	if group != nil {
-		err := group.Delete(key)
-		if err != nil {
-		} else {
-			success = true
-			message = "success"
-		}
 
+// getValue 获取数据,HTTP与gRPC共用
+func (gs *Groups) getValue(groupName string, key string) *pb.GetResponse {
+	group, err := gs.getOrCreateGroup(groupName)
+	var valuer cachebackend.Valuer
+	if err == nil {
+		valuer, err = group.Get(key)
 	}
+	if err != nil {
+		return &pb.GetResponse{Success: false, Message: "fail"}
+	}
+	data := &pb.Data{
+		Group:  groupName,
+		Value:  valuer.Bytes(),
+		Expire: valuer.Expire(),
+	}
+	return &pb.GetResponse{Success: true, Message: "success", Data: data}
+}
 
-	bytes, _ := proto.Marshal(&pb.DelResponse{Success: success, Message: message})
-	w.Header().Set("Content-Type", "application/octet-stream")
-	_, _ = w.Write(bytes)
+// setValue 新增数据,HTTP与gRPC共用
+func (gs *Groups) setValue(groupName string, key string, in *pb.SetRequest) *pb.SetResponse {
+	group, err := gs.getOrCreateGroup(groupName)
+	if err != nil {
+		return &pb.SetResponse{Success: false, Message: "fail"}
+	}
+	expire := group.ExpireAt(in.Ttl)
+	if err := group.Add(key, lru.NewValue(in.Value, expire, groupName)); err != nil {
+		return &pb.SetResponse{Success: false, Message: "fail"}
+	}
+	data := &pb.Data{
+		Group:  groupName,
+		Value:  in.Value,
+		Expire: expire,
+	}
+	return &pb.SetResponse{Success: true, Message: "success", Data: data}
+}
 
+// delValue 删除数据,HTTP与gRPC共用
+func (gs *Groups) delValue(groupName string, key string) *pb.DelResponse {
+	// 分组不存在时其中也不存在该key,视为删除成功
+	if group := gs.GetGroup(groupName); group != nil && group.Delete(key) != nil {
+		return &pb.DelResponse{Success: false, Message: "fail"}
+	}
+	return &pb.DelResponse{Success: true, Message: "success"}
 }
@@ -0,0 +1,172 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"github.com/chenquan/hit/internal/cache"
+	"github.com/chenquan/hit/internal/consts"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExpireAt(t *testing.T) {
+	now := time.Now().Unix()
+	g := NewGroupDefault("expire", 0, WithTTL(time.Minute, time.Hour))
+
+	testCases := map[int64]int64{
+		0:     now + 60,   // 默认时长
+		10:    now + 10,   // 指定时长
+		7200:  now + 3600, // 超过最大时长
+		-1:    now + 3600, // 永不过期受最大时长限制
+		86400: now + 3600,
+	}
+	for ttl, expect := range testCases {
+		if expire := g.ExpireAt(ttl); expire < expect || expire > expect+1 {
+			t.Errorf("ttl %d: expected %d but got %d", ttl, expect, expire)
+		}
+	}
+
+	g = NewGroupDefault("expire", 0)
+	if expire := g.ExpireAt(-1); expire != 0 {
+		t.Errorf("expected no expiry but got %d", expire)
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	s := httptest.NewServer(NewHTTPPool())
+	defer s.Close()
+	NewGroupDefault("ttl", 0)
+
+	testCases := []struct {
+		key    string
+		ttl    int64
+		expect time.Duration
+	}{
+		{"default", 0, consts.DefaultNodeCacheDuration},
+		{"ttl", 5, time.Second * 5},
+		{"forever", -1, 0},
+	}
+	for _, testCase := range testCases {
+		out := &pb.SetResponse{}
+		post(t, s.URL+consts.DefaultBasePath+"/ttl/"+testCase.key,
+			&pb.SetRequest{Group: "ttl", Key: testCase.key, Value: []byte("v"), Ttl: testCase.ttl}, out)
+		if !out.Success {
+			t.Fatalf("set %s failed: %s", testCase.key, out.Message)
+		}
+		var expect int64
+		if testCase.expect > 0 {
+			expect = time.Now().Add(testCase.expect).Unix()
+		}
+		if out.Data.Expire < expect-1 || out.Data.Expire > expect {
+			t.Errorf("key %s: expected expire %d but got %d", testCase.key, expect, out.Data.Expire)
+		}
+
+		v, err := GetGroup("ttl").Get(testCase.key)
+		if err != nil || v.Expire() != out.Data.Expire {
+			t.Errorf("key %s: stored expire mismatch", testCase.key)
+		}
+	}
+}
+
+func TestBatchQuery(t *testing.T) {
+	s := httptest.NewServer(NewHTTPPool())
+	defer s.Close()
+	NewGroupDefault("batch-query", 0)
+
+	// 路径中带key时忽略batch参数,按单个key处理
+	out := &pb.SetResponse{}
+	post(t, s.URL+consts.DefaultBasePath+"/batch-query/k?"+consts.BatchQuery+"="+consts.BatchSet,
+		&pb.SetRequest{Group: "batch-query", Key: "k", Value: []byte("v"), Ttl: -1}, out)
+	if !out.Success {
+		t.Fatalf("set failed: %s", out.Message)
+	}
+	if v, err := GetGroup("batch-query").Get("k"); err != nil || string(v.Bytes()) != "v" {
+		t.Fatalf("expected single key set: %v %v", v, err)
+	}
+
+	multiOut := &pb.MultiGetResponse{}
+	post(t, s.URL+consts.DefaultBasePath+"/batch-query?"+consts.BatchQuery+"="+consts.BatchGet,
+		&pb.MultiGetRequest{Group: "batch-query", Keys: []string{"k"}}, multiOut)
+	if len(multiOut.Results) != 1 || !multiOut.Results[0].Success {
+		t.Fatalf("unexpected batch results %v", multiOut.Results)
+	}
+}
+
+func post(t *testing.T, url string, in, out proto.Message) {
+	body, _ := proto.Marshal(in)
+	res, err := http.Post(url, consts.ContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(res.Body)
+	if err := proto.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// janitorCache 记录后台清理的启动与停止
+type janitorCache struct {
+	*cache.SyncCache
+	interval time.Duration
+	stopped  bool
+}
+
+func (c *janitorCache) StartJanitor(interval time.Duration) {
+	c.interval = interval
+	c.stopped = false
+}
+
+func (c *janitorCache) StopJanitor() {
+	c.stopped = true
+}
+
+func TestJanitorLifecycle(t *testing.T) {
+	groups := NewGroups()
+	groups.SetJanitorInterval(time.Minute)
+	old := &janitorCache{SyncCache: cache.NewSyncCacheDefault(0)}
+	groups.NewGroup("users", old)
+	if old.interval != time.Minute || old.stopped {
+		t.Fatalf("expected janitor to be started with the configured interval")
+	}
+
+	// 替换分组时停止原分组的后台清理
+	replaced := &janitorCache{SyncCache: cache.NewSyncCacheDefault(0)}
+	groups.NewGroup("users", replaced)
+	if !old.stopped || replaced.stopped {
+		t.Fatalf("expected janitor of the replaced group to be stopped")
+	}
+
+	groups.StopJanitors()
+	if !replaced.stopped {
+		t.Fatalf("expected all janitors to be stopped")
+	}
+
+	// 间隔小于等于0时不启动后台清理
+	groups.SetJanitorInterval(0)
+	disabled := &janitorCache{SyncCache: cache.NewSyncCacheDefault(0)}
+	groups.NewGroup("orders", disabled)
+	if disabled.interval != 0 {
+		t.Fatalf("janitor should not be started")
+	}
+}
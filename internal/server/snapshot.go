@@ -0,0 +1,323 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	cachebackend "github.com/chenquan/hit/internal/cache/backend/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 快照文件格式:
+//
+//	magic(7字节) | version(uvarint) | entry... | end(1字节) | crc32(4字节,大端)
+//	entry: 1 | group | key | value | expire(varint)
+//
+// group,key,value均为 长度(uvarint)+内容,crc32为之前所有字节的校验和
+const (
+	snapshotMagic   = "HITSNAP"
+	snapshotVersion = 1
+
+	snapshotEnd   byte = 0
+	snapshotEntry byte = 1
+
+	maxSnapshotField = 1 << 30 // 单个字段的最大长度,防止读取损坏的文件时申请过多内存
+)
+
+// SaveSnapshot 将默认集合中所有分组的数据保存到快照文件
+func SaveSnapshot(path string) (int, error) {
+	return defaultGroups.SaveSnapshot(path)
+}
+
+// LoadSnapshot 从快照文件恢复默认集合中的数据
+func LoadSnapshot(path string) (int, error) {
+	return defaultGroups.LoadSnapshot(path)
+}
+
+// SaveSnapshot 将所有分组的数据保存到快照文件,返回保存的条数.
+// 先写入同目录下的临时文件再重命名,保存失败时不影响已有的快照
+func (gs *Groups) SaveSnapshot(path string) (int, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := gs.WriteSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+// LoadSnapshot 从快照文件恢复数据,返回恢复的条数.快照文件不存在时不做处理
+func (gs *Groups) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return gs.ReadSnapshot(f)
+}
+
+// WriteSnapshot 将所有分组中未过期的数据写入w,返回写入的条数
+func (gs *Groups) WriteSnapshot(w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw, crc: crc32.NewIEEE()}
+	sw.writeString(snapshotMagic)
+	sw.writeUvarint(snapshotVersion)
+
+	n := 0
+	gs.rangeValues(func(group, key string, value cachebackend.Valuer) bool {
+		sw.writeByte(snapshotEntry)
+		sw.writeBytes([]byte(group))
+		sw.writeBytes([]byte(key))
+		sw.writeBytes(value.Bytes())
+		sw.writeVarint(value.Expire())
+		n++
+		return sw.err == nil
+	})
+	sw.writeByte(snapshotEnd)
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, sw.crc.Sum32())
+	sw.write(sum)
+	if sw.err == nil {
+		sw.err = bw.Flush()
+	}
+	return n, sw.err
+}
+
+// snapshotRecord 快照中的一条数据
+type snapshotRecord struct {
+	group, key string
+	value      []byte
+	expire     int64
+}
+
+// ReadSnapshot 从r读取快照并写入未过期的数据,返回写入的条数.
+// 校验和通过后才写入数据,分组不存在且不能自动创建时跳过该分组的数据
+func (gs *Groups) ReadSnapshot(r io.Reader) (int, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	if magic := sr.readFull(len(snapshotMagic)); sr.err == nil && string(magic) != snapshotMagic {
+		return 0, fmt.Errorf("not a snapshot file")
+	}
+	if version := sr.readUvarint(); sr.err == nil && version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version: %d", version)
+	}
+
+	var records []snapshotRecord
+	for sr.err == nil {
+		typ := sr.readByte()
+		if sr.err != nil || typ == snapshotEnd {
+			break
+		}
+		if typ != snapshotEntry {
+			return 0, fmt.Errorf("corrupted snapshot: unknown record type %d", typ)
+		}
+		records = append(records, snapshotRecord{
+			group:  string(sr.readBytes()),
+			key:    string(sr.readBytes()),
+			value:  sr.readBytes(),
+			expire: sr.readVarint(),
+		})
+	}
+	if sr.err != nil {
+		return 0, fmt.Errorf("corrupted snapshot: %v", sr.err)
+	}
+	sum := sr.crc.Sum32()
+	if expect := sr.readFull(4); sr.err != nil || binary.BigEndian.Uint32(expect) != sum {
+		return 0, fmt.Errorf("corrupted snapshot: checksum mismatch")
+	}
+
+	now := time.Now().Unix()
+	n := 0
+	for _, record := range records {
+		if record.expire > 0 && record.expire <= now {
+			continue
+		}
+		g, err := gs.getOrCreateGroup(record.group)
+		if err != nil {
+			continue
+		}
+		g.populateCache(record.key, lru.NewValue(record.value, record.expire, record.group))
+		n++
+	}
+	return n, nil
+}
+
+// rangeValues 按分组名称依次遍历所有未过期的数据,fn返回false时停止.
+// 分组的缓存未实现cachebackend.Ranger时跳过该分组
+func (gs *Groups) rangeValues(fn func(group, key string, value cachebackend.Valuer) bool) {
+	now := time.Now().Unix()
+	stopped := false
+	for _, g := range gs.list() {
+		ranger, ok := g.mainCache.(cachebackend.Ranger)
+		if !ok {
+			log.Printf("[Hit] group %s does not support iteration", g.name)
+			continue
+		}
+		ranger.Range(func(key string, value cachebackend.Valuer) bool {
+			if expire := value.Expire(); expire > 0 && expire <= now {
+				return true
+			}
+			stopped = !fn(g.name, key, value)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// list 按名称排序的分组列表
+func (gs *Groups) list() []*Group {
+	gs.mu.RLock()
+	groups := make([]*Group, 0, len(gs.groups))
+	for _, g := range gs.groups {
+		groups = append(groups, g)
+	}
+	gs.mu.RUnlock()
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+// snapshotWriter 写入快照并计算校验和,出错后忽略之后的写入
+type snapshotWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (w *snapshotWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, _ = w.crc.Write(p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *snapshotWriter) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *snapshotWriter) writeString(s string) {
+	w.write([]byte(s))
+}
+
+func (w *snapshotWriter) writeUvarint(x uint64) {
+	w.write(w.buf[:binary.PutUvarint(w.buf[:], x)])
+}
+
+func (w *snapshotWriter) writeVarint(x int64) {
+	w.write(w.buf[:binary.PutVarint(w.buf[:], x)])
+}
+
+func (w *snapshotWriter) writeBytes(p []byte) {
+	w.writeUvarint(uint64(len(p)))
+	w.write(p)
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// snapshotReader 读取快照并计算校验和,出错后之后的读取均返回零值
+type snapshotReader struct {
+	r   byteReader
+	crc hash.Hash32
+	err error
+}
+
+// ReadByte 实现io.ByteReader,供binary.ReadUvarint使用
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		_, _ = r.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) readByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	var b byte
+	b, r.err = r.ReadByte()
+	return b
+}
+
+func (r *snapshotReader) readFull(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, r.err = io.ReadFull(r.r, p); r.err == io.EOF {
+		r.err = io.ErrUnexpectedEOF
+	}
+	_, _ = r.crc.Write(p)
+	return p
+}
+
+func (r *snapshotReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var x uint64
+	x, r.err = binary.ReadUvarint(r)
+	return x
+}
+
+func (r *snapshotReader) readVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	var x int64
+	x, r.err = binary.ReadVarint(r)
+	return x
+}
+
+func (r *snapshotReader) readBytes() []byte {
+	n := r.readUvarint()
+	if r.err == nil && n > maxSnapshotField {
+		r.err = fmt.Errorf("field too large: %d", n)
+	}
+	return r.readFull(int(n))
+}
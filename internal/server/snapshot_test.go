@@ -0,0 +1,109 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"github.com/chenquan/hit/internal/cache"
+	"github.com/chenquan/hit/internal/cache/lru"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hit-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hit.snapshot")
+
+	expire := time.Now().Add(time.Hour).Unix()
+	groups := NewGroups()
+	users := groups.NewGroup("users", cache.NewSyncCacheDefault(0))
+	_ = users.Add("tom", lru.NewValue([]byte("cat"), expire, "users"))
+	_ = users.Add("jerry", lru.NewValue([]byte("mouse"), 0, "users"))
+	_ = users.Add("expired", lru.NewValue([]byte("v"), time.Now().Add(-time.Second).Unix(), "users"))
+	sessions := groups.NewGroup("sessions", cache.NewShardedSyncCacheDefault(0, 4))
+	_ = sessions.Add("s1", lru.NewValue([]byte("session"), expire, "sessions"))
+
+	if n, err := groups.SaveSnapshot(path); err != nil || n != 3 {
+		t.Fatalf("expected 3 entries saved but got %d: %v", n, err)
+	}
+
+	// 只恢复已配置的分组
+	restored := NewGroups()
+	restored.SetAutoCreate(false, 0)
+	restored.NewGroup("users", cache.NewSyncCacheDefault(0))
+	if n, err := restored.LoadSnapshot(path); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries loaded but got %d: %v", n, err)
+	}
+	g := restored.GetGroup("users")
+	if v, err := g.Get("tom"); err != nil || string(v.Bytes()) != "cat" || v.Expire() != expire {
+		t.Fatalf("unexpected tom: %v %v", v, err)
+	}
+	if v, err := g.Get("jerry"); err != nil || v.Expire() != 0 {
+		t.Fatalf("unexpected jerry: %v %v", v, err)
+	}
+	if _, err := g.Get("expired"); err == nil {
+		t.Fatalf("expired entry should not be restored")
+	}
+	if restored.GetGroup("sessions") != nil {
+		t.Fatalf("unknown group should not be created")
+	}
+
+	// 自动创建分组
+	restored = NewGroups()
+	if n, err := restored.LoadSnapshot(path); err != nil || n != 3 {
+		t.Fatalf("expected 3 entries loaded but got %d: %v", n, err)
+	}
+
+	// 快照文件不存在
+	if n, err := NewGroups().LoadSnapshot(filepath.Join(dir, "missing")); err != nil || n != 0 {
+		t.Fatalf("expected missing snapshot to be ignored: %v", err)
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	groups := NewGroups()
+	g := groups.NewGroup("users", cache.NewSyncCacheDefault(0))
+	_ = g.Add("tom", lru.NewValue([]byte("cat"), 0, "users"))
+	var buf bytes.Buffer
+	if _, err := groups.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	testCases := map[string][]byte{
+		"flipped":   append(append([]byte{}, data[:12]...), append([]byte{data[12] ^ 0xff}, data[13:]...)...),
+		"truncated": data[:len(data)-3],
+		"version":   append([]byte(snapshotMagic+"\x02"), data[len(snapshotMagic)+1:]...),
+		"empty":     nil,
+	}
+	for name, data := range testCases {
+		restored := NewGroups()
+		if n, err := restored.ReadSnapshot(bytes.NewReader(data)); err == nil || n != 0 {
+			t.Fatalf("%s: expected error but loaded %d entries", name, n)
+		}
+		if restored.GetGroup("users") != nil {
+			t.Fatalf("%s: no entries should be loaded from a corrupted snapshot", name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ *    Copyright 2020 Chen Quan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package tlsutil_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/chenquan/hit/client/dial"
+	"github.com/chenquan/hit/internal/consts"
+	pb "github.com/chenquan/hit/internal/remotecache"
+	"github.com/chenquan/hit/internal/server"
+	"github.com/chenquan/hit/internal/tlsutil"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// certificate 测试证书,parent为nil时生成自签名CA
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCertificate(t *testing.T, dir, name string, parent *certificate, usage x509.ExtKeyUsage) (*certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return &certificate{cert: cert, key: key}, certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hit-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caFile, _ := newCertificate(t, dir, "ca", nil, x509.ExtKeyUsageAny)
+	_, serverCert, serverKey := newCertificate(t, dir, "server", ca, x509.ExtKeyUsageServerAuth)
+	_, clientCert, clientKey := newCertificate(t, dir, "client", ca, x509.ExtKeyUsageClientAuth)
+	_, otherCA, _ := newCertificate(t, dir, "other", nil, x509.ExtKeyUsageAny)
+
+	serverConfig, err := tlsutil.ServerConfig(serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(server.NewHTTPPool())
+	s.TLS = serverConfig
+	s.StartTLS()
+	defer s.Close()
+	server.NewGroupDefault("tls", 0)
+
+	node := func(certFile, keyFile, caFile string) *dial.Node {
+		config, err := tlsutil.ClientConfig(certFile, keyFile, caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		return dial.NewNodeWithClient(s.URL+consts.DefaultBasePath, client, time.Second)
+	}
+
+	trusted := node(clientCert, clientKey, caFile)
+	if err := trusted.Set(context.Background(), &pb.SetRequest{Group: "tls", Key: "k", Value: []byte("v")}, &pb.SetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	out := &pb.GetResponse{}
+	if err := trusted.Get(context.Background(), &pb.GetRequest{Group: "tls", Key: "k"}, out); err != nil || string(out.Data.Value) != "v" {
+		t.Fatalf("get over mTLS: %v", err)
+	}
+
+	// 未出示客户端证书
+	if err := node("", "", caFile).Get(context.Background(), &pb.GetRequest{Group: "tls", Key: "k"}, &pb.GetResponse{}); err == nil {
+		t.Fatalf("expected request without client certificate to fail")
+	}
+	// 不信任节点证书
+	if err := node(clientCert, clientKey, otherCA).Get(context.Background(), &pb.GetRequest{Group: "tls", Key: "k"}, &pb.GetResponse{}); err == nil {
+		t.Fatalf("expected untrusted node certificate to be rejected")
+	}
+}
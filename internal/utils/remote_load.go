@@ -16,12 +16,15 @@
 
 package utils
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{}
+	val  interface{}
+	err  error
 }
 
 type Loader struct {
@@ -30,27 +33,38 @@ type Loader struct {
 }
 
 func (l *Loader) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return l.DoContext(context.Background(), key, fn)
+}
+
+// DoContext 同Do,fn在新的协程中执行.调用方(包括首个调用方)的ctx取消或超时时立即返回ctx.Err(),
+// 不影响fn的执行以及等待同一key的其他调用方,因此fn不应使用调用方的ctx
+func (l *Loader) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
 	l.mu.Lock()
 	if l.m == nil {
 		l.m = make(map[string]*call)
 	}
-	if c, ok := l.m[key]; ok {
-		l.mu.Unlock()
-		// 等待先入协程从远端获取数据
-		c.wg.Wait()
-		return c.val, c.err
+	c, ok := l.m[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		l.m[key] = c
+		go l.call(key, c, fn)
 	}
-	c := new(call)
-	c.wg.Add(1)
-	l.m[key] = c
 	l.mu.Unlock()
 
+	// 等待加载完成
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Loader) call(key string, c *call, fn func() (interface{}, error)) {
 	c.val, c.err = fn()
-	c.wg.Done()
+	close(c.done)
 
 	l.mu.Lock()
 	delete(l.m, key)
 	l.mu.Unlock()
-
-	return c.val, c.err
 }
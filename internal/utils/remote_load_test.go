@@ -17,7 +17,9 @@
 package utils
 
 import (
+	"context"
 	"testing"
+	"time"
 )
 
 func TestDo(t *testing.T) {
@@ -30,3 +32,55 @@ func TestDo(t *testing.T) {
 		t.Errorf("Do v = %v, error = %v", v, err)
 	}
 }
+
+func TestDoContext(t *testing.T) {
+	var g Loader
+	start, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = g.Do("key", func() (interface{}, error) {
+			close(start)
+			<-release
+			return "bar", nil
+		})
+	}()
+	<-start
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.DoContext(ctx, "key", func() (interface{}, error) {
+		t.Fatal("fn should not be called while key is loading")
+		return nil, nil
+	}); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded but got %v", err)
+	}
+}
+
+func TestDoContextCanceled(t *testing.T) {
+	var g Loader
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+	// 首个调用方取消不影响其他等待的调用方
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.DoContext(ctx, "key", fn)
+		first <- err
+	}()
+	second := make(chan interface{}, 1)
+	go func() {
+		v, _ := g.DoContext(context.Background(), "key", fn)
+		second <- v
+	}()
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("expected canceled but got %v", err)
+	}
+	close(release)
+	if v := <-second; v != "bar" {
+		t.Errorf("expected bar but got %v", v)
+	}
+}